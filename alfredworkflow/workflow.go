@@ -0,0 +1,211 @@
+// Package alfredworkflow builds the Authy.alfredworkflow bundle from templates,
+// with the path of the authy binary and the search options baked in
+package alfredworkflow
+
+import (
+	"archive/zip"
+	"bytes"
+	"embed"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"text/template"
+)
+
+// iconDir per issuer icons in the workflow folder, see icons/README.md
+const iconDir = "icons"
+
+var (
+	//go:embed info.plist.tmpl
+	infoPlist string
+	//go:embed icon.png
+	icon []byte
+	//go:embed icons
+	issuerIcons embed.FS
+
+	infoTemplate = template.Must(template.New("info.plist").Funcs(template.FuncMap{
+		"xml": xmlEscape,
+	}).Parse(infoPlist))
+)
+
+// uids of the workflow objects, fixed so installing a new bundle replaces the old one
+var uids = struct {
+	Search, Copy, Used, Notify, LargeType, Refresh string
+}{
+	Search:    "AC356E78-96F6-480F-BEB1-0BDD876E5DED",
+	Copy:      "16AACC75-D7CF-4425-BCAA-419EBDD1AB0D",
+	Used:      "5B0E7F4C-2D1A-4C55-9F0E-3C9B6F1A8E21",
+	Notify:    "853616B0-58FF-4ABF-9A77-F41BAFE066CA",
+	LargeType: "9D3C2B1A-7E6F-4A5B-8C9D-0E1F2A3B4C5D",
+	Refresh:   "CE64ED04-6C02-4918-AE23-849C04DFC617",
+}
+
+// Config of a workflow bundle
+type Config struct {
+	// Binary absolute path of the authy binary
+	Binary string
+	// Keyword searching tokens, default "at"
+	Keyword string
+	// RefreshKeyword refreshing the token cache, default "atrefresh"
+	RefreshKeyword string
+	// Sort passed to fuzz --sort, empty keeps its default
+	Sort string
+	// MinRemaining passed to fuzz --min-remaining when set
+	MinRemaining int
+	// Variables workflow environment variables, e.g. AUTHY_ROOT
+	Variables map[string]string
+	Version   string
+	// IconDir issuer icons of the workflow, none are bundled without it
+	IconDir string
+}
+
+// Write the .alfredworkflow zip to w
+func Write(w io.Writer, conf Config) error {
+	if len(conf.Keyword) == 0 {
+		conf.Keyword = "at"
+	}
+
+	if len(conf.RefreshKeyword) == 0 {
+		conf.RefreshKeyword = "atrefresh"
+	}
+
+	var plist bytes.Buffer
+	if err := infoTemplate.Execute(&plist, conf.data()); err != nil {
+		return err
+	}
+
+	icons, err := conf.icons()
+	if err != nil {
+		return err
+	}
+
+	files := []bundleFile{
+		{"info.plist", plist.Bytes()},
+		{"icon.png", icon},
+		{uids.Search + ".png", icon},
+	}
+	files = append(files, icons...)
+
+	zw := zip.NewWriter(w)
+	for _, f := range files {
+		fw, err := zw.Create(f.name)
+		if err != nil {
+			return err
+		}
+
+		if _, err = fw.Write(f.body); err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
+
+type bundleFile struct {
+	name string
+	body []byte
+}
+
+// icons issuer icons embedded at build time, none in this repository, and those
+// of IconDir replacing them by name
+func (c Config) icons() ([]bundleFile, error) {
+	var (
+		icons = []bundleFile{}
+		index = map[string]int{}
+	)
+
+	add := func(fsys fs.FS, dir string) error {
+		entries, err := fs.ReadDir(fsys, dir)
+		if err != nil {
+			return err
+		}
+
+		for _, e := range entries {
+			if e.IsDir() || path.Ext(e.Name()) != ".png" {
+				continue
+			}
+
+			body, err := fs.ReadFile(fsys, path.Join(dir, e.Name()))
+			if err != nil {
+				return err
+			}
+
+			f := bundleFile{path.Join(iconDir, strings.ToLower(e.Name())), body}
+			if i, ok := index[f.name]; ok {
+				icons[i] = f
+				continue
+			}
+
+			index[f.name] = len(icons)
+			icons = append(icons, f)
+		}
+
+		return nil
+	}
+
+	if err := add(issuerIcons, iconDir); err != nil {
+		return nil, err
+	}
+
+	if len(c.IconDir) > 0 {
+		if err := add(os.DirFS(c.IconDir), "."); err != nil {
+			return nil, fmt.Errorf("Read icons failed: %v", err)
+		}
+	}
+
+	return icons, nil
+}
+
+type templateData struct {
+	Config
+	BundleID      string
+	UID           interface{}
+	SearchScript  string
+	UsedScript    string
+	RefreshScript string
+}
+
+func (c Config) data() templateData {
+	bin := shellQuote(c.Binary)
+
+	search := bin + " fuzz --output alfred"
+	if len(c.Sort) > 0 {
+		search += " --sort " + shellQuote(c.Sort)
+	}
+	if c.MinRemaining > 0 {
+		search += fmt.Sprintf(" --min-remaining %d", c.MinRemaining)
+	}
+	search += ` -- "$1"`
+
+	refresh := strings.Join([]string{
+		"if " + bin + " refresh </dev/null >/dev/null 2>&1; then",
+		`  echo '{"items":[{"title":"Authy tokens refreshed","subtitle":"Local token cache is up to date","valid":false}]}'`,
+		"else",
+		`  echo '{"items":[{"title":"Refresh failed","subtitle":"Run authy refresh in a terminal","valid":false}]}'`,
+		"fi",
+	}, "\n")
+
+	return templateData{
+		Config:        c,
+		BundleID:      "com.github.momaek.authy",
+		UID:           uids,
+		SearchScript:  search,
+		UsedScript:    bin + ` used "$token_id" >/dev/null 2>&1`,
+		RefreshScript: refresh,
+	}
+}
+
+func xmlEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// shellQuote single quote s for sh
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
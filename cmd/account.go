@@ -2,36 +2,90 @@ package cmd
 
 import (
 	"fmt"
+	"log"
+	"time"
+
 	"github.com/momaek/authy/service"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 )
 
-// accountCmd represents the account command
-var (
-	countrycode, mobile, password string
-
-	accountCmd = &cobra.Command{
-		Use:   "account",
-		Short: "Authy account info or register device",
-		Long: `Register device or show registered account info. 
-
-Can specify country code, mobile number and authy main password.
-If not provided, will get from command line stdin`,
-		Run: func(cmd *cobra.Command, args []string) {
-			device := service.NewDevice(service.NewDeviceConfig{
-				CountryCode: countrycode,
-				Mobile:      mobile,
-				Password:    password,
-			})
-			fmt.Printf("%#v \n", device)
-		},
+// deviceFlags registration and password flags, each command has its own
+type deviceFlags struct {
+	countryCode, mobile, password string
+	passwordFile, passwordCommand string
+	via                           string
+	registerTimeout, pollInterval time.Duration
+}
+
+// config device config from the flags
+func (f *deviceFlags) config() service.NewDeviceConfig {
+	return service.NewDeviceConfig{
+		CountryCode:     f.countryCode,
+		Mobile:          f.mobile,
+		Password:        f.password,
+		PasswordFile:    f.passwordFile,
+		PasswordCommand: f.passwordCommand,
+		Via:             f.via,
+		RegisterTimeout: f.registerTimeout,
+		PollInterval:    f.pollInterval,
 	}
-)
+}
+
+func (f *deviceFlags) hasPassword() bool {
+	return len(f.password) > 0 || len(f.passwordFile) > 0 || len(f.passwordCommand) > 0
+}
+
+// addRegisterFlags flags used when the device isn't registered yet
+func (f *deviceFlags) addRegisterFlags(fs *pflag.FlagSet) {
+	fs.StringVarP(&f.countryCode, "countrycode", "c", "", "phone number country code (e.g. 1 for United States), digitals only")
+	fs.StringVarP(&f.mobile, "mobilenumber", "m", "", "phone number, digitals only")
+	fs.StringVar(&f.via, "via", "", "registration method: push, sms or call (default push, or AUTHY_REGISTER_VIA)")
+	fs.DurationVar(&f.registerTimeout, "register-timeout", 5*time.Minute, "give up the registration after this long")
+	fs.DurationVar(&f.pollInterval, "poll-interval", 5*time.Second, "first interval between registration status checks, backing off up to 30s")
+}
+
+func (f *deviceFlags) addPasswordFlags(fs *pflag.FlagSet) {
+	fs.StringVarP(&f.password, "password", "p", "", "authy main password")
+	fs.StringVar(&f.passwordFile, "password-file", "", "read authy main password from the first line of this file")
+	fs.StringVar(&f.passwordCommand, "password-command", "", "read authy main password from the output of this shell command, e.g. 'pass show authy'")
+}
+
+var accountFlags deviceFlags
+
+// accountCmd represents the account command
+var accountCmd = &cobra.Command{
+	Use:   "account",
+	Short: "Authy account info or register device",
+	Long: `Register device or show registered account info. 
+
+Can specify country code, mobile number and authy main password, with flags or
+AUTHY_COUNTRY_CODE, AUTHY_MOBILE, AUTHY_PASSWORD, AUTHY_PASSWORD_FILE and
+AUTHY_PASSWORD_COMMAND. A password given here is saved for later refreshes.
+If not provided, will get from command line stdin, and fail when stdin is not a terminal.
+
+The device is registered by accepting a push notification in your Authy app, or
+with a PIN received by SMS or phone call:
+
+  authy account --via sms --register-timeout 10m`,
+	Run: func(cmd *cobra.Command, args []string) {
+		device := service.NewDevice(accountFlags.config())
+		if accountFlags.hasPassword() {
+			ctx, stop := signalContext()
+			defer stop()
+
+			if err := device.SaveMainPassword(ctx); err != nil {
+				log.Fatal("Save password failed ", err)
+			}
+		}
+		reg := device.Registration()
+		fmt.Printf("User ID: %d\nDevice ID: %d\n", reg.UserID, reg.DeviceID)
+	},
+}
 
 func init() {
 	rootCmd.AddCommand(accountCmd)
 
-	accountCmd.Flags().StringVarP(&countrycode, "countrycode", "c", "", "phone number country code (e.g. 1 for United States), digitals only")
-	accountCmd.Flags().StringVarP(&mobile, "mobilenumber", "m", "", "phone number, digitals only")
-	accountCmd.Flags().StringVarP(&password, "password", "p", "", "authy main password")
+	accountFlags.addRegisterFlags(accountCmd.Flags())
+	accountFlags.addPasswordFlags(accountCmd.Flags())
 }
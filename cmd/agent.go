@@ -0,0 +1,237 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/momaek/authy/service"
+	"github.com/spf13/cobra"
+)
+
+var (
+	agentIdle        time.Duration
+	agentSocket      string
+	agentForeground  bool
+	agentTokensStdin bool
+)
+
+// agentCmd represents the agent command
+var agentCmd = &cobra.Command{
+	Use:   "agent",
+	Short: "Start an agent keeping your tokens in memory",
+	Long: `Start an agent, like ssh-agent, that decrypts your tokens from the Authy server
+and keeps them only in memory, serving codes over a Unix socket to processes of the
+same user. The token cache is neither read for secrets nor written.
+
+  eval $(authy agent)
+
+exports AUTHY_AGENT_SOCK, after which fuzz, code and watch ask the agent instead of
+reading the token cache. The agent locks itself after --idle without requests,
+run 'authy agent unlock' to hand it the tokens again.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if agentForeground {
+			runAgent()
+			return
+		}
+
+		startAgent()
+	},
+}
+
+// startAgent decrypt the tokens, possibly prompting for the password, and pass them
+// to a detached agent process
+func startAgent() {
+	tokens := agentTokens()
+
+	exe, err := os.Executable()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	args := []string{"agent", "--foreground", "--tokens-stdin", "--idle", agentIdle.String()}
+	if len(agentSocket) > 0 {
+		args = append(args, "--socket", agentSocket)
+	}
+
+	child := exec.Command(exe, args...)
+	child.Stderr = os.Stderr
+	service.Detach(child)
+
+	stdin, err := child.StdinPipe()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	stdout, err := child.StdoutPipe()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err = child.Start(); err != nil {
+		log.Fatal("Start agent failed ", err)
+	}
+
+	err = json.NewEncoder(stdin).Encode(tokens)
+	stdin.Close()
+	if err != nil {
+		log.Fatal("Pass tokens to agent failed ", err)
+	}
+
+	// the agent closes stdout once it listens
+	n, _ := io.Copy(os.Stdout, stdout)
+	if n == 0 {
+		child.Wait()
+		log.Fatal("Agent failed to start")
+	}
+
+	child.Process.Release()
+}
+
+// runAgent serve until stopped
+func runAgent() {
+	var tokens []*service.Token
+	if agentTokensStdin {
+		if err := json.NewDecoder(os.Stdin).Decode(&tokens); err != nil {
+			log.Fatal("Read tokens failed ", err)
+		}
+	} else {
+		tokens = agentTokens()
+	}
+
+	agent := service.NewAgent(tokens, agentIdle)
+	if err := agent.Listen(agentSocket); err != nil {
+		log.Fatal("Listen failed ", err)
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sig
+		agent.Close()
+	}()
+
+	w := bufio.NewWriter(os.Stdout)
+	fmt.Fprintf(w, "%s=%s; export %s;\n", service.AgentSockEnv, agent.Path(), service.AgentSockEnv)
+	fmt.Fprintf(w, "AUTHY_AGENT_PID=%d; export AUTHY_AGENT_PID;\n", os.Getpid())
+	fmt.Fprintf(w, "echo Agent pid %d;\n", os.Getpid())
+	w.Flush()
+
+	if agentTokensStdin {
+		os.Stdout.Close()
+	}
+
+	if err := agent.Serve(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// agentTokens tokens decrypted from the Authy server, never written to the token cache
+func agentTokens() []*service.Token {
+	device := service.NewDevice(service.NewDeviceConfig{})
+	tokens, result, err := device.DecryptTokens(context.Background())
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if len(result.Failures) > 0 {
+		result.WriteReport(os.Stderr)
+	}
+
+	return tokens
+}
+
+// agentClient client of the agent in AUTHY_AGENT_SOCK
+func agentClient() *service.AgentClient {
+	client := service.AgentFromEnv()
+	if client == nil {
+		log.Fatalf("%s is not set, start an agent with 'eval $(authy agent)'", service.AgentSockEnv)
+	}
+
+	return client
+}
+
+var agentStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show whether the agent is running and unlocked",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		status, err := agentClient().Status()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		state := "unlocked"
+		if status.Locked {
+			state = "locked"
+		}
+
+		idle := "never"
+		if status.IdleTimeout > 0 {
+			idle = (time.Duration(status.IdleTimeout) * time.Second).String()
+		}
+
+		fmt.Printf("Agent pid %d is %s, %d token(s), locks after %s idle\n", status.Pid, state, status.Tokens, idle)
+	},
+}
+
+var agentLockCmd = &cobra.Command{
+	Use:   "lock",
+	Short: "Make the agent forget the tokens",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := agentClient().Lock(); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+var agentUnlockCmd = &cobra.Command{
+	Use:   "unlock",
+	Short: "Decrypt the tokens from the Authy server into a locked agent",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		client := agentClient()
+		if err := client.Unlock(agentTokens()); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+var agentStopCmd = &cobra.Command{
+	Use:   "stop",
+	Short: "Stop the agent",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		err := agentClient().Stop()
+		if errors.Is(err, service.ErrAgentUnreachable) {
+			log.Fatal("Agent is not running")
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		fmt.Printf("unset %s;\nunset AUTHY_AGENT_PID;\n", service.AgentSockEnv)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(agentCmd)
+	agentCmd.AddCommand(agentStatusCmd, agentLockCmd, agentUnlockCmd, agentStopCmd)
+
+	agentCmd.Flags().DurationVar(&agentIdle, "idle", 15*time.Minute, "Lock after this long without requests, 0 never locks")
+	agentCmd.Flags().StringVar(&agentSocket, "socket", "", "Socket path, default a new private directory in the temp dir")
+	agentCmd.Flags().BoolVar(&agentForeground, "foreground", false, "Run in the foreground instead of as a daemon")
+	agentCmd.Flags().BoolVar(&agentTokensStdin, "tokens-stdin", false, "Read the tokens as JSON from stdin")
+	agentCmd.Flags().MarkHidden("tokens-stdin")
+}
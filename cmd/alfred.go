@@ -0,0 +1,108 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+
+	"github.com/momaek/authy/alfredworkflow"
+	"github.com/spf13/cobra"
+)
+
+var (
+	bundleOutput         string
+	bundleBinary         string
+	bundleKeyword        string
+	bundleRefreshKeyword string
+	bundleSort           string
+	bundleMinRemaining   int
+	bundleInstall        bool
+	bundleIcons          string
+)
+
+// alfredCmd represents the alfred command
+var alfredCmd = &cobra.Command{
+	Use:   "alfred",
+	Short: "Alfred workflow tools",
+}
+
+var alfredBundleCmd = &cobra.Command{
+	Use:   "bundle",
+	Short: "Generate the Alfred workflow for this binary",
+	Long: `Generate Authy.alfredworkflow calling this binary by its absolute path,
+so Alfred needs no PATH setup. AUTHY_ROOT, when set, is kept as workflow variable.
+No issuer icons ship with authy, those in --icons are bundled as
+icons/<issuer>.png, e.g. icons/github.png.
+
+  authy alfred bundle --install`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		bin := bundleBinary
+		if len(bin) == 0 {
+			exe, err := os.Executable()
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			if bin, err = filepath.EvalSymlinks(exe); err != nil {
+				log.Fatal(err)
+			}
+		}
+
+		vars := map[string]string{}
+		if root := os.Getenv("AUTHY_ROOT"); len(root) > 0 {
+			vars["AUTHY_ROOT"] = root
+		}
+
+		f, err := os.Create(bundleOutput)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		err = alfredworkflow.Write(f, alfredworkflow.Config{
+			Binary:         bin,
+			Keyword:        bundleKeyword,
+			RefreshKeyword: bundleRefreshKeyword,
+			Sort:           bundleSort,
+			MinRemaining:   bundleMinRemaining,
+			Variables:      vars,
+			Version:        Version,
+			IconDir:        bundleIcons,
+		})
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		fmt.Printf("Alfred workflow written to %s\n", bundleOutput)
+
+		if bundleInstall {
+			if runtime.GOOS != "darwin" {
+				log.Fatal("--install needs macOS, open the workflow file with Alfred")
+			}
+
+			if err = exec.Command("open", bundleOutput).Run(); err != nil {
+				log.Fatal(err)
+			}
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(alfredCmd)
+	alfredCmd.AddCommand(alfredBundleCmd)
+
+	alfredBundleCmd.Flags().StringVarP(&bundleOutput, "output", "o", "Authy.alfredworkflow", "Workflow file to write")
+	alfredBundleCmd.Flags().StringVar(&bundleBinary, "binary", "", "Path of the authy binary the workflow runs, default this one")
+	alfredBundleCmd.Flags().StringVar(&bundleKeyword, "keyword", "at", "Alfred keyword searching tokens")
+	alfredBundleCmd.Flags().StringVar(&bundleRefreshKeyword, "refresh-keyword", "atrefresh", "Alfred keyword refreshing the token cache")
+	alfredBundleCmd.Flags().StringVar(&bundleSort, "sort", "", "Sort results by frecency, name, issuer or recent")
+	alfredBundleCmd.Flags().IntVar(&bundleMinRemaining, "min-remaining", 0, "Show the next code when the current one expires in less than N seconds")
+	alfredBundleCmd.Flags().StringVar(&bundleIcons, "icons", "", "Directory of more issuer icons, named like github.png")
+	alfredBundleCmd.Flags().BoolVar(&bundleInstall, "install", false, "Open the workflow with Alfred to install it")
+}
@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"bufio"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/momaek/authy/service"
+	"github.com/spf13/cobra"
+)
+
+var (
+	copyCode       bool
+	clipboardMode  string
+	clipboardCmd   string
+	clearAfter     int
+	clearDelay     int
+	clearHashStdin bool
+)
+
+// addClipboardFlags flags of commands copying codes, defaults come from AUTHY_CLIPBOARD,
+// AUTHY_CLIPBOARD_CMD and AUTHY_CLIPBOARD_CLEAR
+func addClipboardFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&clipboardMode, "clipboard", "", "How to copy: auto, osc52 (works over SSH and tmux) or helper")
+	cmd.Flags().StringVar(&clipboardCmd, "clipboard-cmd", "", "Helper command reading the code from stdin, e.g. 'xclip -selection clipboard'")
+	cmd.Flags().IntVar(&clearAfter, "clear-after", 0, "Clear the clipboard after N seconds, default when the code expires")
+}
+
+func clipboardConfig(cmd *cobra.Command) service.ClipboardConfig {
+	c := service.ClipboardConfigFromEnv()
+	if cmd.Flags().Changed("clipboard") {
+		c.Mode = clipboardMode
+	}
+
+	if cmd.Flags().Changed("clipboard-cmd") {
+		c.Command = clipboardCmd
+	}
+
+	if cmd.Flags().Changed("clear-after") {
+		c.ClearAfter = time.Duration(clearAfter) * time.Second
+	}
+
+	return c
+}
+
+// clipboardClearCmd represents the clipboard-clear command, started detached after copying a code
+var clipboardClearCmd = &cobra.Command{
+	Use:    "clipboard-clear",
+	Short:  "Clear the clipboard after a delay",
+	Hidden: true,
+	Args:   cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		hash := ""
+		if clearHashStdin {
+			line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+			if hash = strings.TrimSpace(line); len(hash) == 0 {
+				log.Println("Read clipboard hash failed, clearing anyway", err)
+			}
+		}
+
+		time.Sleep(time.Duration(clearDelay) * time.Second)
+		if err := clipboardConfig(cmd).ClearIfHolds(hash); err != nil {
+			log.Println("Clear clipboard failed", err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(clipboardClearCmd)
+	addClipboardFlags(clipboardClearCmd)
+	clipboardClearCmd.Flags().IntVar(&clearDelay, "after", 0, "seconds to wait")
+	clipboardClearCmd.Flags().BoolVar(&clearHashStdin, "hash-stdin", false, "only clear while the clipboard holds the text whose sha256 is read from stdin")
+}
@@ -0,0 +1,102 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/momaek/authy/service"
+	"github.com/spf13/cobra"
+)
+
+// exit codes of the code command
+const (
+	exitNoMatch   = 2
+	exitAmbiguous = 3
+	exitNoSecret  = 4
+)
+
+// codeCmd represents the code command
+var codeCmd = &cobra.Command{
+	Use:   "code <query>",
+	Short: "Print the code of exactly one token, for scripting",
+	Long: `Print only the current code of exactly one token, for scripting.
+With --copy the code is put on the clipboard instead of printed.
+
+The token is found by id, exact name, or a query matching only one token.
+Exit codes:
+  2  no token matches
+  3  more than one token matches
+  4  the token has no secret`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		device := service.NewDevice(service.NewDeviceConfig{})
+		out, err := resolveCode(device, service.CodeRequest{
+			Query:        joinArgs(args),
+			MinRemaining: minRemaining,
+			Wait:         waitFresh,
+		})
+		if err != nil {
+			exitWithError(err)
+		}
+
+		if device.Revoked() {
+			fmt.Fprintln(os.Stderr, service.ErrDeviceRevoked)
+		}
+
+		if out.ValidIn > 0 {
+			fmt.Fprintf(os.Stderr, "Code of the next period, valid in %d second(s)\n", out.ValidIn)
+		}
+
+		if copyCode {
+			if err = device.CopyCode(out, clipboardConfig(cmd)); err != nil {
+				exitWithError(err)
+			}
+
+			fmt.Fprintf(os.Stderr, "Copied code of %s\n", out.Title())
+			return
+		}
+
+		fmt.Println(out.Code)
+		device.RecordUsage(out.Token)
+	},
+}
+
+// resolveCode from the agent if one is running, otherwise from the local cache
+func resolveCode(device *service.Device, req service.CodeRequest) (service.Output, error) {
+	if agent := service.AgentFromEnv(); agent != nil {
+		out, err := agent.ResolveCode(req)
+		if !errors.Is(err, service.ErrAgentUnreachable) {
+			return out, err
+		}
+
+		log.Println(err)
+	}
+
+	device.LoadTokenFromCache()
+	return device.ResolveCode(req)
+}
+
+func exitWithError(err error) {
+	fmt.Fprintln(os.Stderr, err)
+
+	var ambiguous *service.AmbiguousTokenError
+	switch {
+	case errors.Is(err, service.ErrTokenNotFound):
+		os.Exit(exitNoMatch)
+	case errors.As(err, &ambiguous):
+		os.Exit(exitAmbiguous)
+	case errors.Is(err, service.ErrEmptySecret):
+		os.Exit(exitNoSecret)
+	}
+
+	os.Exit(1)
+}
+
+func init() {
+	rootCmd.AddCommand(codeCmd)
+	addFreshFlags(codeCmd)
+	addClipboardFlags(codeCmd)
+	codeCmd.Flags().BoolVar(&copyCode, "copy", false, "Copy the code to clipboard instead of printing it, cleared when it expires")
+}
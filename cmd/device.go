@@ -0,0 +1,117 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/momaek/authy/service"
+	"github.com/spf13/cobra"
+)
+
+var reregisterFlags deviceFlags
+
+// deviceCmd represents the device command
+var deviceCmd = &cobra.Command{
+	Use:   "device",
+	Short: "Check, register again or remove this device",
+	Long: `Manage the device registration saved in .authy.json.
+
+The Authy API used by status can be changed with AUTHY_API_URL, e.g. to test
+against a fake server. Registering and refreshing always use the Authy API`,
+}
+
+var deviceStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Check the device is still authorized in Authy",
+	Long: `Check the device is still authorized in Authy, with the saved credentials.
+
+Exits with status 1 when it isn't`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		device := loadDevice(service.NewDeviceConfig{})
+
+		ctx, stop := signalContext()
+		defer stop()
+
+		status, err := device.Status(ctx)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		fmt.Printf("User id: %d\nDevice id: %d\nCached tokens: %d\n", status.UserID, status.DeviceID, status.CachedTokens)
+		if !status.Authorized {
+			fmt.Printf("Status: not authorized (%s)\nRun 'authy device reregister' to register this device again\n", status.Message)
+			os.Exit(1)
+		}
+
+		fmt.Println("Status: authorized")
+	},
+}
+
+var deviceReregisterCmd = &cobra.Command{
+	Use:   "reregister",
+	Short: "Register this computer as a new device, keeping the token cache",
+	Long: `Register this computer as a new device, replacing the saved registration.
+
+The token cache and the saved backup password are kept. The old device stays in
+your Authy account until you remove it there`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		device := loadDevice(reregisterFlags.config())
+
+		ctx, stop := signalContext()
+		defer stop()
+
+		devInfo, err := device.Reregister(ctx)
+		if err != nil {
+			// logged by the registration
+			os.Exit(1)
+		}
+
+		log.Println("Register device successfully!!!")
+		log.Printf("Your device id: %v\n", devInfo.DeviceID)
+	},
+}
+
+var deviceRemoveCmd = &cobra.Command{
+	Use:   "remove",
+	Short: "Delete the saved device registration",
+	Long: `Delete the saved device registration, the token cache is kept.
+
+The device stays in your Authy account, remove it there in the Authy app`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		device := loadDevice(service.NewDeviceConfig{})
+		if err := device.Remove(); err != nil {
+			log.Fatal(err)
+		}
+
+		fmt.Println("Device registration removed, remove the device from your Authy account in the Authy app")
+	},
+}
+
+// loadDevice existing device registration, exits when there is none
+func loadDevice(conf service.NewDeviceConfig) *service.Device {
+	device, err := service.LoadDevice(conf)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return device
+}
+
+// signalContext cancelled by Ctrl-C
+func signalContext() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+}
+
+func init() {
+	rootCmd.AddCommand(deviceCmd)
+	deviceCmd.AddCommand(deviceStatusCmd, deviceReregisterCmd, deviceRemoveCmd)
+
+	reregisterFlags.addRegisterFlags(deviceReregisterCmd.Flags())
+}
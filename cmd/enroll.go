@@ -0,0 +1,260 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/momaek/authy/enroll"
+	"github.com/momaek/authy/totp"
+	"github.com/momaek/authy/verifier"
+	qrcode "github.com/skip2/go-qrcode"
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// defaultUsersFile enrollment file of the radius and enroll commands
+const defaultUsersFile = "authy-users.json"
+
+var (
+	enrollUsers    string
+	enrollIssuer   string
+	enrollSize     int
+	enrollDigits   int
+	enrollPeriod   int
+	enrollPassword bool
+	enrollQRFile   string
+	enrollNoPrompt bool
+	enrollForce    bool
+	enrollState    string
+)
+
+// enrollCmd represents the enroll command
+var enrollCmd = &cobra.Command{
+	Use:   "enroll",
+	Short: "Manage users of the RADIUS server",
+	Long: `Manage the users in the enrollment file of 'authy radius'.
+
+New users get a random secret, shown as otpauth URI and QR code to scan with
+their authenticator app. Their enrollment stays pending, and their logins are
+rejected, until the first code they enter is verified.`,
+}
+
+var enrollAddCmd = &cobra.Command{
+	Use:   "add <user>",
+	Short: "Enroll a user with a new secret",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		name := args[0]
+		users := loadUsers()
+
+		if _, err := users.User(name); err == nil && !enrollForce {
+			log.Fatalf("%s is already enrolled, replace the enrollment with --force", name)
+		}
+
+		u, err := enroll.NewUser(enrollIssuer, name, enrollSize, enrollDigits, enrollPeriod)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if enrollPassword {
+			if err = u.SetPassword(readNewPassword()); err != nil {
+				log.Fatal(err)
+			}
+		}
+
+		if err = users.Add(name, u, enrollForce); err != nil {
+			log.Fatal(err)
+		}
+
+		if err = users.Save(); err != nil {
+			log.Fatal(err)
+		}
+
+		showEnrollment(u)
+
+		if enrollNoPrompt || !terminal.IsTerminal(int(os.Stdin.Fd())) {
+			fmt.Printf("\nEnrollment of %s is pending, activate it with 'authy enroll activate %s <code>'\n", name, name)
+			return
+		}
+
+		store := enrollStore()
+		sc := bufio.NewScanner(os.Stdin)
+		for tries := 0; tries < 3; tries++ {
+			fmt.Print("\nEnter the code shown by the authenticator app: ")
+			if !sc.Scan() {
+				break
+			}
+
+			err = users.Activate(name, strings.TrimSpace(sc.Text()), store, time.Now())
+			if errors.Is(err, enroll.ErrInvalidCode) {
+				fmt.Println("Invalid code, check the clock of the device and try again")
+				continue
+			}
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			if err = users.Save(); err != nil {
+				log.Fatal(err)
+			}
+
+			fmt.Printf("Enrollment of %s is active\n", name)
+			return
+		}
+
+		fmt.Printf("\nEnrollment of %s is still pending, activate it with 'authy enroll activate %s <code>'\n", name, name)
+		os.Exit(1)
+	},
+}
+
+var enrollActivateCmd = &cobra.Command{
+	Use:   "activate <user> <code>",
+	Short: "Activate a pending enrollment with the user's first code",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		users := loadUsers()
+		if err := users.Activate(args[0], args[1], enrollStore(), time.Now()); err != nil {
+			log.Fatal(err)
+		}
+
+		if err := users.Save(); err != nil {
+			log.Fatal(err)
+		}
+
+		fmt.Printf("Enrollment of %s is active\n", args[0])
+	},
+}
+
+var enrollListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List enrolled users",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		users := loadUsers()
+
+		names := make([]string, 0, len(users.Users))
+		for name := range users.Users {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			u := users.Users[name]
+			state := "active"
+			if u.Pending {
+				state = "pending"
+			}
+
+			password := "code only"
+			if len(u.PasswordHash) > 0 {
+				password = "password+code"
+			}
+
+			fmt.Printf("%s\t%s\t%s\n", name, state, password)
+		}
+	},
+}
+
+var enrollRemoveCmd = &cobra.Command{
+	Use:   "remove <user>",
+	Short: "Remove an enrolled user",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		users := loadUsers()
+		if err := users.Remove(args[0]); err != nil {
+			log.Fatal(err)
+		}
+
+		if err := users.Save(); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+func loadUsers() *enroll.File {
+	users, err := enroll.Load(enrollUsers)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return users
+}
+
+// enrollStore state of the radius server, activation codes are marked used in it
+func enrollStore() verifier.Store {
+	return verifier.NewFileStore(stateFile(enrollState, enrollUsers))
+}
+
+// stateFile state of used codes and lockouts, by default next to the enrollment file,
+// so 'authy radius' and 'authy enroll' share it without being told
+func stateFile(state, users string) string {
+	if len(state) > 0 {
+		return state
+	}
+
+	return strings.TrimSuffix(users, filepath.Ext(users)) + ".state.json"
+}
+
+func readNewPassword() string {
+	fmt.Print("Password of the user: ")
+	password, err := terminal.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Println()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Print("Repeat the password: ")
+	again, err := terminal.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Println()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if string(password) != string(again) {
+		log.Fatal("Passwords do not match")
+	}
+
+	return string(password)
+}
+
+// showEnrollment print the otpauth URI and its QR code
+func showEnrollment(u *enroll.User) {
+	fmt.Println(u.OTPAuth)
+
+	qr, err := qrcode.New(u.OTPAuth, qrcode.Medium)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Print(qr.ToSmallString(false))
+
+	if len(enrollQRFile) > 0 {
+		if err = qr.WriteFile(256, enrollQRFile); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("QR code written to %s\n", enrollQRFile)
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(enrollCmd)
+	enrollCmd.AddCommand(enrollAddCmd, enrollActivateCmd, enrollListCmd, enrollRemoveCmd)
+
+	enrollCmd.PersistentFlags().StringVar(&enrollUsers, "users", defaultUsersFile, "Enrollment file")
+	enrollCmd.PersistentFlags().StringVar(&enrollState, "state", "", "State file of 'authy radius', so activation codes can't be used to log in, default next to the enrollment file")
+	enrollAddCmd.Flags().StringVar(&enrollIssuer, "issuer", "authy", "Issuer shown in the authenticator app")
+	enrollAddCmd.Flags().IntVar(&enrollSize, "bytes", totp.DefaultSecretSize, "Secret length in bytes")
+	enrollAddCmd.Flags().IntVar(&enrollDigits, "digits", 6, "Code length")
+	enrollAddCmd.Flags().IntVar(&enrollPeriod, "period", totp.INTERVAL, "Seconds each code is valid")
+	enrollAddCmd.Flags().BoolVar(&enrollPassword, "password", false, "Ask for a password, logins then need the password followed by the code")
+	enrollAddCmd.Flags().StringVar(&enrollQRFile, "qr", "", "Also write the QR code as PNG to this file")
+	enrollAddCmd.Flags().BoolVar(&enrollForce, "force", false, "Replace the enrollment of a user that is already enrolled")
+	enrollAddCmd.Flags().BoolVar(&enrollNoPrompt, "no-prompt", false, "Don't ask for the first code, activate later with 'enroll activate'")
+}
@@ -1,8 +1,8 @@
 package cmd
 
 import (
-
-	//"time"
+	"log"
+	"strings"
 
 	"github.com/momaek/authy/service"
 	"github.com/spf13/cobra"
@@ -10,32 +10,95 @@ import (
 
 // fuzzCmd represents the fuzz command
 var fuzzCmd = &cobra.Command{
-	Use:   "fuzz",
-	Short: "Fuzzy search your otp tokens(case-insensitive)",
+	Use:     "fuzz [query]",
+	Aliases: []string{"list"},
+	Short:   "Fuzzy search your otp tokens(case-insensitive)",
 	Long: `Fuzzy search your otp tokens(case-insensitive)
 
+Query syntax, terms separated by spaces, all terms must match:
+  word          fuzzy match against name, issuer, account and tags
+  "some words"  substring match
+  /regexp/      regular expression match
+  -term         exclude tokens matching term
+  name:term     only match one field: name, issuer, account or tag
+
+Put '--' before a query starting with '-', e.g. authy fuzz -- -tag:work
+
+--wait only applies to the pretty output, launchers and status bars polling the
+other formats get the next period's code instead
+
 First time(or after clean cache) , need your authy main password`,
 	Run: func(cmd *cobra.Command, args []string) {
 		var (
-			isAlfred = false
-			keyword  = ""
+			format  = outputFormat
+			keyword = ""
 		)
 		if alfredCount != nil && *alfredCount > 0 {
-			isAlfred = true
+			format = service.FormatAlfred
 		}
 
 		if len(args) > 0 {
-			keyword = args[0]
+			keyword = joinArgs(args)
 		}
 
-		service.NewSearcher(keyword, isAlfred).Search()
+		order, err := service.ParseSortOrder(sortOrder)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		renderer, err := service.NewRenderer(format, outputTemplate)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		service.NewSearcher(service.SearcherConfig{
+			Keyword:      keyword,
+			Sort:         order,
+			Renderer:     renderer,
+			MinRemaining: minRemaining,
+			Wait:         waitFresh && service.CanWait(format),
+			Copy:         copyCode,
+			Clipboard:    clipboardConfig(cmd),
+		}).Search()
 
 	},
 }
 
-var alfredCount *int
+// joinArgs query from all positional arguments
+func joinArgs(args []string) string {
+	return strings.Join(args, " ")
+}
+
+var (
+	minRemaining int
+	waitFresh    bool
+)
+
+// addFreshFlags flags of commands producing codes
+func addFreshFlags(cmd *cobra.Command) {
+	addMinRemainingFlag(cmd)
+	cmd.Flags().BoolVar(&waitFresh, "wait", false, "With --min-remaining, wait for the next period instead")
+}
+
+// addMinRemainingFlag flag of commands run by launchers, they never wait
+func addMinRemainingFlag(cmd *cobra.Command) {
+	cmd.Flags().IntVar(&minRemaining, "min-remaining", 0, "Codes expiring in less than N seconds are replaced by the next period's code")
+}
+
+var (
+	alfredCount    *int
+	sortOrder      string
+	outputFormat   string
+	outputTemplate string
+)
 
 func init() {
 	rootCmd.AddCommand(fuzzCmd)
 	alfredCount = fuzzCmd.Flags().CountP("alfred", "a", "Specify Output Mode AlfredWorkflow")
+	fuzzCmd.Flags().StringVarP(&outputFormat, "output", "o", service.FormatPretty, "Output format: pretty, alfred, json, jsonl, csv, tsv, plain, template, rofi, dmenu, fzf, ulauncher, waybar, i3blocks, polybar, tmux or raycast")
+	fuzzCmd.Flags().StringVar(&outputTemplate, "template", "", "Go text/template for --output template, e.g. '{{.Title}} {{.Code}}'")
+	addFreshFlags(fuzzCmd)
+	addClipboardFlags(fuzzCmd)
+	fuzzCmd.Flags().BoolVar(&copyCode, "copy", false, "Copy the code of the first result to clipboard, cleared when it expires")
+	fuzzCmd.Flags().StringVarP(&sortOrder, "sort", "s", string(service.SortFrecency), "Sort results by frecency, name, issuer or recent")
 }
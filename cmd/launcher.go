@@ -0,0 +1,119 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/momaek/authy/service"
+	"github.com/spf13/cobra"
+)
+
+var selectPreview bool
+
+// selectCmd represents the select command
+var selectCmd = &cobra.Command{
+	Use:   "select [line]",
+	Short: "Print the code of a line chosen in dmenu or fzf",
+	Long: `Print, or copy with --copy, the current code of the token in a line printed by
+'fuzz --output dmenu' or 'fuzz --output fzf'. The line is read from stdin when
+not given. The code is computed again, so it is fresh even if the launcher
+stayed open for a while. It never waits for a fresh code, with --min-remaining
+the next period's code is given instead.
+
+  authy fuzz -o dmenu | dmenu -i -l 10 | authy select --copy
+  authy fuzz -o fzf | fzf --delimiter '\t' --with-nth 2.. --preview 'authy select --preview {}' | authy select`,
+	Run: func(cmd *cobra.Command, args []string) {
+		line := joinArgs(args)
+		if len(args) == 0 {
+			sc := bufio.NewScanner(os.Stdin)
+			if sc.Scan() {
+				line = sc.Text()
+			}
+		}
+
+		key := service.SelectedKey(line)
+		if len(key) == 0 {
+			// nothing chosen in the launcher
+			os.Exit(1)
+		}
+
+		device := service.NewDevice(service.NewDeviceConfig{})
+		out, err := resolveCode(device, service.CodeRequest{Query: key, MinRemaining: minRemaining})
+		if err != nil {
+			exitWithError(err)
+		}
+
+		if device.Revoked() {
+			fmt.Fprintln(os.Stderr, service.ErrDeviceRevoked)
+		}
+
+		if selectPreview {
+			renderer, _ := service.NewRenderer(service.FormatPretty, "")
+			renderer.Render(os.Stdout, []service.Output{out})
+			return
+		}
+
+		if copyCode {
+			if err = device.CopyCode(out, clipboardConfig(cmd)); err != nil {
+				exitWithError(err)
+			}
+			return
+		}
+
+		fmt.Println(out.Code)
+		device.RecordUsage(out.Token)
+	},
+}
+
+// rofiCmd represents the rofi command
+var rofiCmd = &cobra.Command{
+	Use:   "rofi",
+	Short: "rofi script mode listing your codes",
+	Long: `rofi script mode: lists all tokens, issuer, account and tags are searchable,
+the chosen code is copied to clipboard. Nothing waits for a fresh code, with
+--min-remaining the next period's code is copied instead.
+
+  rofi -show authy -modi "authy:authy rofi"`,
+	Run: func(cmd *cobra.Command, args []string) {
+		// rofi runs the script again with ROFI_RETV=1 once an entry is chosen
+		if os.Getenv("ROFI_RETV") == "1" {
+			device := service.NewDevice(service.NewDeviceConfig{})
+			out, err := resolveCode(device, service.CodeRequest{Query: os.Getenv("ROFI_INFO"), MinRemaining: minRemaining})
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			if err = device.CopyCode(out, clipboardConfig(cmd)); err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+
+		order, err := service.ParseSortOrder(sortOrder)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		renderer, _ := service.NewRenderer(service.FormatRofi, "")
+		service.NewSearcher(service.SearcherConfig{
+			Sort:         order,
+			Renderer:     renderer,
+			MinRemaining: minRemaining,
+		}).Search()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(selectCmd, rofiCmd)
+
+	addMinRemainingFlag(selectCmd)
+	addClipboardFlags(selectCmd)
+	selectCmd.Flags().BoolVar(&copyCode, "copy", false, "Copy the code to clipboard instead of printing it, cleared when it expires")
+	selectCmd.Flags().BoolVar(&selectPreview, "preview", false, "Show the token and its code, for fzf --preview")
+
+	addMinRemainingFlag(rofiCmd)
+	addClipboardFlags(rofiCmd)
+	rofiCmd.Flags().StringVarP(&sortOrder, "sort", "s", string(service.SortFrecency), "Sort results by frecency, name, issuer or recent")
+}
@@ -0,0 +1,34 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/momaek/authy/service"
+	"github.com/spf13/cobra"
+)
+
+// noteCmd represents the note command
+var noteCmd = &cobra.Command{
+	Use:   "note <query> [note]",
+	Short: "Show or set notes of a token",
+	Long: `Show or set notes of a token.
+
+Without note, show the current notes, an empty note removes them`,
+	Args: cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		device := service.NewDevice(service.NewDeviceConfig{})
+		tk := resolveToken(device, args[0])
+
+		if len(args) == 1 {
+			fmt.Println(tk.Notes)
+			return
+		}
+
+		tk.Notes = args[1]
+		device.SaveTokens()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(noteCmd)
+}
@@ -0,0 +1,139 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/momaek/authy/enroll"
+	"github.com/momaek/authy/radiusd"
+	"github.com/momaek/authy/verifier"
+	"github.com/spf13/cobra"
+	"layeh.com/radius"
+	"layeh.com/radius/rfc2865"
+)
+
+var (
+	radiusAddr   string
+	radiusSecret string
+	radiusUsers  string
+	radiusState  string
+)
+
+// radiusCmd represents the radius command
+var radiusCmd = &cobra.Command{
+	Use:   "radius",
+	Short: "Run a RADIUS server checking TOTP codes of enrolled users",
+	Long: `Run a RADIUS (PAP) server checking TOTP codes of the users in the enrollment file.
+
+Users with a password log in with the password followed by the current code,
+e.g. hunter2123456, users without password with the code only. Every code is
+accepted once, and users are locked out for a while after 5 failed attempts.
+
+The enrollment file is read again when it changes, or on SIGHUP, so users added
+or activated with 'authy enroll' can log in without a restart. Used codes and
+lockouts are kept in --state, by default next to the enrollment file, which
+'authy enroll' shares so activation codes can't be used to log in.
+
+The shared secret comes from --secret or AUTHY_RADIUS_SECRET. The enrollment file
+is JSON, see 'authy enroll' or write it by hand:
+
+  {"users": {"alice": {"otpauth": "otpauth://totp/VPN:alice?secret=...",
+                       "password_hash": "<bcrypt hash>"}}}`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		secret := radiusSharedSecret()
+
+		users, err := enroll.Watch(radiusUsers)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		srv := &radius.PacketServer{
+			Addr:         radiusAddr,
+			SecretSource: radius.StaticSecretSource([]byte(secret)),
+			Handler:      radiusd.NewHandler(users, verifier.NewFileStore(stateFile(radiusState, radiusUsers))),
+		}
+
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+		go func() {
+			for s := range sig {
+				if s != syscall.SIGHUP {
+					srv.Shutdown(context.Background())
+					return
+				}
+
+				if err := users.Reload(); err != nil {
+					log.Printf("Reload %s failed: %v", radiusUsers, err)
+					continue
+				}
+				log.Printf("Reloaded %d user(s)", users.Len())
+			}
+		}()
+
+		log.Printf("Listening on %s with %d user(s)", radiusAddr, users.Len())
+		if err = srv.ListenAndServe(); err != nil && !errors.Is(err, radius.ErrServerShutdown) {
+			log.Fatal(err)
+		}
+	},
+}
+
+// radiusTestCmd represents the radius test command
+var radiusTestCmd = &cobra.Command{
+	Use:   "test <user> <password>",
+	Short: "Send an Access-Request to a RADIUS server",
+	Long: `Send an Access-Request to a RADIUS server, e.g. one started with 'authy radius'.
+Exits 0 when accepted and 1 when rejected`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		packet := radius.New(radius.CodeAccessRequest, []byte(radiusSharedSecret()))
+		rfc2865.UserName_SetString(packet, args[0])
+		rfc2865.UserPassword_SetString(packet, args[1])
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		resp, err := radius.Exchange(ctx, packet, radiusAddr)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		fmt.Println(resp.Code)
+		if msg := rfc2865.ReplyMessage_GetString(resp); len(msg) > 0 {
+			fmt.Println(msg)
+		}
+
+		if resp.Code != radius.CodeAccessAccept {
+			os.Exit(1)
+		}
+	},
+}
+
+func radiusSharedSecret() string {
+	if len(radiusSecret) > 0 {
+		return radiusSecret
+	}
+
+	if secret := os.Getenv("AUTHY_RADIUS_SECRET"); len(secret) > 0 {
+		return secret
+	}
+
+	log.Fatal("Please set the shared secret with --secret or AUTHY_RADIUS_SECRET")
+	return ""
+}
+
+func init() {
+	rootCmd.AddCommand(radiusCmd)
+	radiusCmd.AddCommand(radiusTestCmd)
+
+	radiusCmd.PersistentFlags().StringVar(&radiusAddr, "addr", ":1812", "Address to listen on, or of the server to test")
+	radiusCmd.PersistentFlags().StringVar(&radiusSecret, "secret", "", "RADIUS shared secret")
+	radiusCmd.Flags().StringVar(&radiusUsers, "users", defaultUsersFile, "Enrollment file")
+	radiusCmd.Flags().StringVar(&radiusState, "state", "", "File keeping used codes and lockouts, default next to the enrollment file, e.g. authy-users.state.json")
+}
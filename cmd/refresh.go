@@ -1,23 +1,47 @@
 package cmd
 
 import (
+	"log"
+	"os"
+
 	"github.com/momaek/authy/service"
 	"github.com/spf13/cobra"
 )
 
+var refreshFlags deviceFlags
+
 // refreshCmd represents the refresh command
 var refreshCmd = &cobra.Command{
 	Use:   "refresh",
 	Short: "Refresh token cache",
 	Long: `When you add a new token.
 
-You can use this cmd to refresh local token cache`,
+You can use this cmd to refresh local token cache.
+
+The backup password is taken from --password, --password-file, --password-command
+(or AUTHY_PASSWORD, AUTHY_PASSWORD_FILE, AUTHY_PASSWORD_COMMAND, run by the shell),
+else the saved one, else asked on the terminal:
+
+  authy refresh --password-command 'pass show authy'
+
+Tokens that fail to decrypt are listed with the reason, their previous copies
+stay in the cache`,
 	Run: func(cmd *cobra.Command, args []string) {
-		device := service.NewDevice(service.NewDeviceConfig{})
-		device.LoadTokenFromAuthyServer()
+		device := service.NewDevice(refreshFlags.config())
+		ctx, stop := signalContext()
+		defer stop()
+
+		result, err := device.Refresh(ctx)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		result.WriteReport(os.Stdout)
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(refreshCmd)
+	refreshFlags.addRegisterFlags(refreshCmd.Flags())
+	refreshFlags.addPasswordFlags(refreshCmd.Flags())
 }
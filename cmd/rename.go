@@ -0,0 +1,28 @@
+package cmd
+
+import (
+	"strings"
+
+	"github.com/momaek/authy/service"
+	"github.com/spf13/cobra"
+)
+
+// renameCmd represents the rename command
+var renameCmd = &cobra.Command{
+	Use:   "rename <query> <new name>",
+	Short: "Set a local display name for a token",
+	Long: `Set a local display name for a token.
+
+The name in your Authy account is not changed, an empty name restores it`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		device := service.NewDevice(service.NewDeviceConfig{})
+		tk := resolveToken(device, args[0])
+		tk.Alias = strings.TrimSpace(args[1])
+		device.SaveTokens()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(renameCmd)
+}
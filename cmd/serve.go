@@ -0,0 +1,121 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/momaek/authy/service"
+	"github.com/spf13/cobra"
+)
+
+var (
+	serveAddr      string
+	serveSocket    string
+	serveTokenFile string
+)
+
+// serveCmd represents the serve command
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Serve codes over a local HTTP/JSON API",
+	Long: `Serve codes over a local HTTP/JSON API, on localhost or a Unix socket.
+
+Every request needs the bearer token, taken from AUTHY_SERVE_TOKEN or generated
+at start and printed (or written to --token-file):
+
+  GET /v1/tokens              codes of all tokens
+  GET /v1/search?q=query      codes of tokens matching the query
+  GET /v1/code?q=query        code of exactly one token, 404 none, 409 ambiguous
+  GET /v1/events?q=query      Server-Sent Events with new codes at each period rollover
+
+  curl -H "Authorization: Bearer $TOKEN" 'http://127.0.0.1:8765/v1/code?q=github'
+
+Only /v1/events also takes the token as access_token parameter, for browsers'
+EventSource which can't set headers. URLs end up in logs and histories, prefer
+the header everywhere else
+
+Codes come from the agent when AUTHY_AGENT_SOCK is set`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		token := os.Getenv("AUTHY_SERVE_TOKEN")
+		if len(token) == 0 {
+			var err error
+			if token, err = service.GenerateServerToken(); err != nil {
+				log.Fatal(err)
+			}
+		}
+
+		l, err := serveListener()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if len(serveTokenFile) > 0 {
+			if err = os.WriteFile(serveTokenFile, []byte(token+"\n"), 0600); err != nil {
+				log.Fatal(err)
+			}
+			fmt.Fprintf(os.Stderr, "Listening on %s, token written to %s\n", l.Addr(), serveTokenFile)
+		} else if len(os.Getenv("AUTHY_SERVE_TOKEN")) == 0 {
+			fmt.Fprintf(os.Stderr, "Listening on %s, token %s\n", l.Addr(), token)
+		} else {
+			fmt.Fprintf(os.Stderr, "Listening on %s\n", l.Addr())
+		}
+
+		srv := &http.Server{Handler: service.NewServer(codeSource(), token)}
+
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			<-sig
+			srv.Close()
+		}()
+
+		if err = srv.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	},
+}
+
+// serveListener Unix socket only accessible by the current user, or a loopback address
+func serveListener() (net.Listener, error) {
+	if len(serveSocket) > 0 {
+		return service.ListenUnix(serveSocket)
+	}
+
+	host, _, err := net.SplitHostPort(serveAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return nil, fmt.Errorf("Refusing to listen on %s, only loopback addresses are allowed", serveAddr)
+	}
+
+	return net.Listen("tcp", serveAddr)
+}
+
+// codeSource the agent if one is configured, otherwise the local token cache
+func codeSource() service.CodeSource {
+	if agent := service.AgentFromEnv(); agent != nil {
+		return agent
+	}
+
+	device := service.NewDevice(service.NewDeviceConfig{})
+	device.LoadTokenFromCache()
+	// loaded once up front, requests are served concurrently
+	device.LoadUsage()
+	return device
+}
+
+func init() {
+	rootCmd.AddCommand(serveCmd)
+	serveCmd.Flags().StringVar(&serveAddr, "addr", "127.0.0.1:8765", "Loopback address to listen on")
+	serveCmd.Flags().StringVar(&serveSocket, "socket", "", "Listen on this Unix socket instead of --addr")
+	serveCmd.Flags().StringVar(&serveTokenFile, "token-file", "", "Write the bearer token to this file instead of printing it")
+}
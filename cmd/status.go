@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"log"
+	"os"
+
+	"github.com/momaek/authy/service"
+	"github.com/spf13/cobra"
+)
+
+var statusFormat string
+
+// statusCmd represents the status command
+var statusCmd = &cobra.Command{
+	Use:   "status [query]",
+	Short: "Print one code for status bars",
+	Long: `Print the code of one token and its remaining seconds for status bars, marked
+as expiring shortly before it changes, and as revoked while the codes come from
+the cache of a revoked device.
+
+The token is the best match of the query, of AUTHY_STATUS_TOKEN when no query is
+given, or else your first favorite. Run it every second:
+
+  Waybar    "custom/authy": {"exec": "authy status -o waybar", "return-type": "json", "interval": 1}
+            classes ok, expiring, revoked and error
+  i3blocks  command=authy status -o i3blocks
+            interval=1
+  Polybar   type = custom/script, exec = authy status -o polybar, interval = 1
+  tmux      set -g status-right '#(authy status -o tmux)'
+            set -g status-interval 1
+  Raycast   script command with "# @raycast.mode inline" and
+            "# @raycast.refreshTime 10s" running authy status -o raycast`,
+	Run: func(cmd *cobra.Command, args []string) {
+		query := joinArgs(args)
+		if len(query) == 0 {
+			query = os.Getenv("AUTHY_STATUS_TOKEN")
+		}
+
+		renderer, err := service.NewRenderer(statusFormat, "")
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		service.NewSearcher(service.SearcherConfig{
+			Keyword:  query,
+			Sort:     service.SortFrecency,
+			Renderer: service.PinnedRenderer(renderer),
+		}).Search()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(statusCmd)
+	statusCmd.Flags().StringVarP(&statusFormat, "output", "o", service.FormatPlain, "Output format: waybar, i3blocks, polybar, tmux, raycast or any fuzz format")
+}
@@ -0,0 +1,71 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/momaek/authy/service"
+	"github.com/spf13/cobra"
+)
+
+// tagCmd represents the tag command
+var (
+	deleteTags, favorite, unfavorite bool
+
+	tagCmd = &cobra.Command{
+		Use:   "tag <query> [tags...]",
+		Short: "Add, remove or list tags of a token",
+		Long: `Add, remove or list tags of a token.
+
+Without tags, show the current tags.
+Use --favorite/--unfavorite to pin a token to the top of the results`,
+		Args: cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			device := service.NewDevice(service.NewDeviceConfig{})
+			tk := resolveToken(device, args[0])
+
+			tags := args[1:]
+			if len(tags) == 0 && !favorite && !unfavorite {
+				fmt.Println(strings.Join(tk.Tags, ", "))
+				return
+			}
+
+			if deleteTags {
+				tk.RemoveTags(tags...)
+			} else {
+				tk.AddTags(tags...)
+			}
+
+			if favorite {
+				tk.Favorite = true
+			}
+
+			if unfavorite {
+				tk.Favorite = false
+			}
+
+			device.SaveTokens()
+		},
+	}
+)
+
+// resolveToken load tokens and find the only one matching query, exit on failure
+func resolveToken(device *service.Device, query string) *service.Token {
+	device.LoadTokenFromCache()
+
+	tk, err := device.ResolveToken(query)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return tk
+}
+
+func init() {
+	rootCmd.AddCommand(tagCmd)
+
+	tagCmd.Flags().BoolVarP(&deleteTags, "delete", "d", false, "remove the given tags instead of adding them")
+	tagCmd.Flags().BoolVar(&favorite, "favorite", false, "mark token as favorite")
+	tagCmd.Flags().BoolVar(&unfavorite, "unfavorite", false, "unmark token as favorite")
+}
@@ -0,0 +1,33 @@
+package cmd
+
+import (
+	"log"
+
+	"github.com/momaek/authy/service"
+	"github.com/spf13/cobra"
+)
+
+// usedCmd represents the used command
+var usedCmd = &cobra.Command{
+	Use:   "used <token id>",
+	Short: "Record that a token's code was used",
+	Long: `Record that a token's code was used, so it ranks higher next time.
+
+Launchers call this with the token id of the selected item`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		device := service.NewDevice(service.NewDeviceConfig{})
+		device.LoadTokenFromCache()
+
+		tk := device.FindToken(args[0])
+		if tk == nil {
+			log.Fatalf("Token %s not found", args[0])
+		}
+
+		device.RecordUsage(tk)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(usedCmd)
+}
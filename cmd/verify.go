@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/momaek/authy/service"
+	"github.com/momaek/authy/totp"
+	"github.com/spf13/cobra"
+)
+
+// exitInvalidCode the code does not match, distinct from the code command's
+// exit codes and from 1 for any other error
+const exitInvalidCode = 5
+
+var verifySkew int
+
+// verifyCmd represents the verify command
+var verifyCmd = &cobra.Command{
+	Use:   "verify <query> <code>",
+	Short: "Check a code against one of your tokens",
+	Long: `Check a code against one of your tokens, e.g. to find out how far the clock of
+another device or service drifts.
+
+The token is found like in the code command. Codes of up to --skew periods before
+and after now are accepted, the matching offset is printed: -1 means the code is
+one period old, so the clock that generated it is behind.
+Exit codes:
+  0  the code matches
+  1  any other error
+  2  no token matches
+  3  more than one token matches
+  4  the token has no secret
+  5  the code does not match`,
+	Args: cobra.MinimumNArgs(2),
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if verifySkew < 0 {
+			return fmt.Errorf("--skew must be 0 or more, got %d", verifySkew)
+		}
+
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		device := service.NewDevice(service.NewDeviceConfig{})
+		device.LoadTokenFromCache()
+
+		tk, err := device.ResolveToken(joinArgs(args[:len(args)-1]))
+		if err != nil {
+			exitWithError(err)
+		}
+
+		offset, ok, err := service.VerifyCode(tk, args[len(args)-1], verifySkew, time.Now())
+		if err != nil {
+			exitWithError(err)
+		}
+
+		if !ok {
+			fmt.Printf("Invalid code for %s within ±%d period(s)\n", tk.Title(), verifySkew)
+			os.Exit(exitInvalidCode)
+		}
+
+		drift := ""
+		switch {
+		case offset < 0:
+			drift = fmt.Sprintf(", clock is about %ds behind", -offset*tk.PeriodSecs())
+		case offset > 0:
+			drift = fmt.Sprintf(", clock is about %ds ahead", offset*tk.PeriodSecs())
+		}
+
+		fmt.Printf("Valid code for %s, offset %+d%s\n", tk.Title(), offset, drift)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(verifyCmd)
+	verifyCmd.Flags().IntVar(&verifySkew, "skew", totp.DefaultSkew, "Accept codes up to N periods before or after now")
+}
@@ -0,0 +1,34 @@
+package cmd
+
+import (
+	"log"
+
+	"github.com/momaek/authy/service"
+	"github.com/spf13/cobra"
+)
+
+// watchCmd represents the watch command
+var watchCmd = &cobra.Command{
+	Use:   "watch [query]",
+	Short: "Live view of your otp codes with countdowns",
+	Long: `Full-screen live view of your otp codes with countdowns.
+
+Type to filter with the same query syntax as fuzz, ↑/↓ or Ctrl-P/Ctrl-N to select,
+Enter to copy the selected code to clipboard, Esc or Ctrl-C to quit`,
+	Run: func(cmd *cobra.Command, args []string) {
+		order, err := service.ParseSortOrder(sortOrder)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if err = service.NewWatcher(joinArgs(args), order, clipboardConfig(cmd)).Run(); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(watchCmd)
+	addClipboardFlags(watchCmd)
+	watchCmd.Flags().StringVarP(&sortOrder, "sort", "s", string(service.SortFrecency), "Sort results by frecency, name, issuer or recent")
+}
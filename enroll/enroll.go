@@ -0,0 +1,215 @@
+// Package enroll keeps the users allowed to log in with TOTP codes, each with the
+// otpauth URI of their secret and optionally a password hash
+package enroll
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/momaek/authy/totp"
+	"github.com/momaek/authy/verifier"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	// ErrUnknownUser user is not enrolled
+	ErrUnknownUser = errors.New("Unknown user")
+	// ErrPending enrollment waits for the user's first code
+	ErrPending = errors.New("Enrollment not activated yet")
+	// ErrInvalidCode first code does not match
+	ErrInvalidCode = errors.New("Invalid code")
+	// ErrUserExists user is already enrolled
+	ErrUserExists = errors.New("User is already enrolled")
+)
+
+// User one enrolled user
+type User struct {
+	// OTPAuth otpauth://totp/ URI holding the secret
+	OTPAuth string `json:"otpauth"`
+	// PasswordHash bcrypt hash, when set logins need the password followed by the code
+	PasswordHash string `json:"password_hash,omitempty"`
+	// Pending the user has not entered a first code yet, logins are rejected
+	Pending bool `json:"pending,omitempty"`
+}
+
+// NewUser pending user with a new random secret of size bytes. digits and period
+// follow the rules of totp.ParseURI, so the enrollment file can be loaded again
+func NewUser(issuer, account string, size, digits, period int) (*User, error) {
+	if !totp.ValidDigits(digits) {
+		return nil, fmt.Errorf("Invalid digits %d, codes have 1 to 9 digits", digits)
+	}
+
+	if !totp.ValidPeriod(period) {
+		return nil, fmt.Errorf("Invalid period %d, codes are valid for at least 1 second", period)
+	}
+
+	secret, err := totp.GenerateSecret(size)
+	if err != nil {
+		return nil, err
+	}
+
+	key := totp.Key{
+		Secret:  secret,
+		Issuer:  issuer,
+		Account: account,
+		Digits:  digits,
+		Period:  period,
+	}
+
+	return &User{OTPAuth: key.URI(), Pending: true}, nil
+}
+
+// Key secret and code parameters of the user
+func (u User) Key() (totp.Key, error) {
+	return totp.ParseURI(u.OTPAuth)
+}
+
+// CheckPassword compare password with the password hash
+func (u User) CheckPassword(password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(password)) == nil
+}
+
+// SetPassword store the bcrypt hash of password, empty password logs in with the code only
+func (u *User) SetPassword(password string) error {
+	if len(password) == 0 {
+		u.PasswordHash = ""
+		return nil
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	u.PasswordHash = string(hash)
+	return nil
+}
+
+// File enrollment file, a JSON object of users by name
+//
+//	{"users": {"alice": {"otpauth": "otpauth://totp/VPN:alice?secret=...", "password_hash": "$2a$10$..."}}}
+type File struct {
+	path  string
+	Users map[string]*User `json:"users"`
+}
+
+// Load enrollment file at path, empty if it does not exist
+func Load(path string) (*File, error) {
+	f := &File{path: path, Users: map[string]*User{}}
+
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return f, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err = json.Unmarshal(b, f); err != nil {
+		return nil, fmt.Errorf("Invalid enrollment file %s: %v", path, err)
+	}
+
+	if f.Users == nil {
+		f.Users = map[string]*User{}
+	}
+
+	for name, u := range f.Users {
+		if _, err = u.Key(); err != nil {
+			return nil, fmt.Errorf("Invalid otpauth URI of %s: %v", name, err)
+		}
+	}
+
+	return f, nil
+}
+
+// User enrolled user by name
+func (f *File) User(name string) (*User, error) {
+	u, ok := f.Users[name]
+	if !ok {
+		return nil, ErrUnknownUser
+	}
+
+	return u, nil
+}
+
+// Add enroll user under name, an earlier enrollment is only replaced with replace
+func (f *File) Add(name string, u *User, replace bool) error {
+	if _, ok := f.Users[name]; ok && !replace {
+		return ErrUserExists
+	}
+
+	f.Users[name] = u
+	return nil
+}
+
+// Remove ..
+func (f *File) Remove(name string) error {
+	if _, ok := f.Users[name]; !ok {
+		return ErrUnknownUser
+	}
+
+	delete(f.Users, name)
+	return nil
+}
+
+// Activate check the first code of a pending user at now and activate the enrollment.
+// The code is checked by a verifier on store, the state of 'authy radius', so it
+// counts as used and can't be replayed to log in
+func (f *File) Activate(name, code string, store verifier.Store, now time.Time) error {
+	u, err := f.User(name)
+	if err != nil {
+		return err
+	}
+
+	key, err := u.Key()
+	if err != nil {
+		return err
+	}
+
+	v := verifier.New(verifier.Config{
+		Digits: key.Digits,
+		Period: key.Period,
+		Store:  store,
+		Clock:  verifier.ClockFunc(func() time.Time { return now }),
+	})
+
+	_, err = v.Verify(name, key.Secret, code)
+	if errors.Is(err, verifier.ErrInvalidCode) || errors.Is(err, verifier.ErrReplayedCode) {
+		return ErrInvalidCode
+	}
+	if err != nil {
+		return err
+	}
+
+	u.Pending = false
+	return nil
+}
+
+// Save write the file, only readable by the current user
+func (f *File) Save() error {
+	b, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), filepath.Base(f.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), f.path)
+}
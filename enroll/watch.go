@@ -0,0 +1,91 @@
+package enroll
+
+import (
+	"log"
+	"os"
+	"sync"
+)
+
+// Watcher enrollment file loaded again whenever it changes on disk, so users added or
+// activated by 'authy enroll' take effect in a running server
+type Watcher struct {
+	path string
+
+	mu   sync.Mutex
+	file *File
+	info os.FileInfo
+}
+
+// Watch load the enrollment file at path and follow its changes
+func Watch(path string) (*Watcher, error) {
+	w := &Watcher{path: path}
+	if err := w.Reload(); err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
+
+// Reload load the file again even if it looks unchanged, e.g. on SIGHUP
+func (w *Watcher) Reload() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.load(w.stat())
+}
+
+// User enrolled user by name, from the file as it is now
+func (w *Watcher) User(name string) (*User, error) {
+	return w.current().User(name)
+}
+
+// Len number of enrolled users
+func (w *Watcher) Len() int {
+	return len(w.current().Users)
+}
+
+// current the loaded file, loaded again first if it changed. A file that
+// became invalid is logged and the users loaded before are kept
+func (w *Watcher) current() *File {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	info := w.stat()
+	if changed(w.info, info) {
+		if err := w.load(info); err != nil {
+			log.Printf("Reload %s failed, keeping the users loaded before: %v", w.path, err)
+		}
+	}
+
+	return w.file
+}
+
+func (w *Watcher) load(info os.FileInfo) error {
+	f, err := Load(w.path)
+	if err != nil {
+		return err
+	}
+
+	w.file, w.info = f, info
+	return nil
+}
+
+// stat nil if the file does not exist
+func (w *Watcher) stat() os.FileInfo {
+	info, err := os.Stat(w.path)
+	if err != nil {
+		return nil
+	}
+
+	return info
+}
+
+// changed Save replaces the file, so a new file is a change even within the
+// resolution of the modification time
+func changed(old, info os.FileInfo) bool {
+	if old == nil || info == nil {
+		return old != info
+	}
+
+	return !os.SameFile(old, info) || !old.ModTime().Equal(info.ModTime()) || old.Size() != info.Size()
+}
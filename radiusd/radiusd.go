@@ -0,0 +1,139 @@
+// Package radiusd answers RADIUS PAP Access-Requests of enrolled users with TOTP codes,
+// so VPN concentrators and network gear can use them as second factor
+package radiusd
+
+import (
+	"errors"
+	"log"
+	"sync"
+
+	"github.com/momaek/authy/enroll"
+	"github.com/momaek/authy/totp"
+	"github.com/momaek/authy/verifier"
+	"layeh.com/radius"
+	"layeh.com/radius/rfc2865"
+)
+
+// ErrWrongPassword password before the code does not match
+var ErrWrongPassword = errors.New("Wrong password")
+
+// Users enrolled users, an *enroll.File or an *enroll.Watcher following the file
+type Users interface {
+	User(name string) (*enroll.User, error)
+}
+
+// Handler radius.Handler checking User-Password as password followed by the code,
+// or only the code for users without password
+type Handler struct {
+	users Users
+	store verifier.Store
+
+	mu        sync.Mutex
+	verifiers map[[2]int]*verifier.Verifier
+}
+
+// NewHandler users from the enrollment file, replay and lockout state in store
+func NewHandler(users Users, store verifier.Store) *Handler {
+	if store == nil {
+		store = verifier.NewMemoryStore()
+	}
+
+	return &Handler{
+		users:     users,
+		store:     store,
+		verifiers: map[[2]int]*verifier.Verifier{},
+	}
+}
+
+// verifier sharing the store for the code parameters of key
+func (h *Handler) verifier(key totp.Key) *verifier.Verifier {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	params := [2]int{key.Digits, key.Period}
+	v, ok := h.verifiers[params]
+	if !ok {
+		v = verifier.New(verifier.Config{
+			Digits: key.Digits,
+			Period: key.Period,
+			Store:  h.store,
+		})
+		h.verifiers[params] = v
+	}
+
+	return v
+}
+
+// Authenticate check password of user. The password is checked before the code, a
+// wrong one counts as a failed attempt without using up the code, so password
+// guesses run into the same lockout as code guesses
+func (h *Handler) Authenticate(name, password string) (verifier.Result, error) {
+	u, err := h.users.User(name)
+	if err != nil {
+		return verifier.Result{}, err
+	}
+
+	if u.Pending {
+		return verifier.Result{}, enroll.ErrPending
+	}
+
+	key, err := u.Key()
+	if err != nil {
+		return verifier.Result{}, err
+	}
+
+	digits := key.Digits
+	if digits <= 0 {
+		digits = 6
+	}
+
+	if len(password) < digits {
+		return verifier.Result{}, verifier.ErrInvalidCode
+	}
+
+	v := h.verifier(key)
+	pass, code := password[:len(password)-digits], password[len(password)-digits:]
+	if !checkPassword(u, pass) {
+		if err = v.Fail(name); err != nil {
+			return verifier.Result{}, err
+		}
+
+		return verifier.Result{}, ErrWrongPassword
+	}
+
+	return v.Verify(name, key.Secret, code)
+}
+
+// checkPassword users without password hash log in with the code only
+func checkPassword(u *enroll.User, pass string) bool {
+	if len(u.PasswordHash) == 0 {
+		return len(pass) == 0
+	}
+
+	return u.CheckPassword(pass)
+}
+
+// ServeRADIUS ..
+func (h *Handler) ServeRADIUS(w radius.ResponseWriter, r *radius.Request) {
+	if r.Code != radius.CodeAccessRequest {
+		log.Printf("Ignore %v from %v", r.Code, r.RemoteAddr)
+		return
+	}
+
+	name := rfc2865.UserName_GetString(r.Packet)
+	password := rfc2865.UserPassword_GetString(r.Packet)
+
+	res, err := h.Authenticate(name, password)
+	if err != nil {
+		log.Printf("Reject %q from %v: %v", name, r.RemoteAddr, err)
+		resp := r.Response(radius.CodeAccessReject)
+		if errors.Is(err, verifier.ErrLocked) {
+			rfc2865.ReplyMessage_SetString(resp, err.Error())
+		}
+		w.Write(resp)
+		return
+	}
+
+	log.Printf("Accept %q from %v, code offset %+d", name, r.RemoteAddr, res.Offset)
+	w.Write(r.Response(radius.CodeAccessAccept))
+}
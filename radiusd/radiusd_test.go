@@ -0,0 +1,209 @@
+package radiusd
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/momaek/authy/enroll"
+	"github.com/momaek/authy/totp"
+	"github.com/momaek/authy/verifier"
+	"layeh.com/radius"
+	"layeh.com/radius/rfc2865"
+)
+
+const (
+	testSecret       = "JBSWY3DPEHPK3PXP"
+	testSharedSecret = "radius-secret"
+)
+
+// startServer serve the users on a loopback port until the test ends
+func startServer(t *testing.T, users *enroll.File) string {
+	t.Helper()
+
+	return startServerWith(t, users, nil)
+}
+
+// startServerWith serve users with state in store
+func startServerWith(t *testing.T, users Users, store verifier.Store) string {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := &radius.PacketServer{
+		SecretSource: radius.StaticSecretSource([]byte(testSharedSecret)),
+		Handler:      NewHandler(users, store),
+	}
+
+	go srv.Serve(conn)
+	t.Cleanup(func() {
+		srv.Shutdown(context.Background())
+	})
+
+	return conn.LocalAddr().String()
+}
+
+func testUsers(t *testing.T) *enroll.File {
+	t.Helper()
+
+	users, err := enroll.Load(filepath.Join(t.TempDir(), "users.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alice := &enroll.User{OTPAuth: totp.Key{Secret: testSecret, Account: "alice"}.URI()}
+	if err = alice.SetPassword("hunter2"); err != nil {
+		t.Fatal(err)
+	}
+
+	users.Add("alice", alice, false)
+	users.Add("bob", &enroll.User{OTPAuth: totp.Key{Secret: testSecret, Account: "bob"}.URI()}, false)
+	users.Add("carol", &enroll.User{OTPAuth: totp.Key{Secret: testSecret, Account: "carol"}.URI(), Pending: true}, false)
+	return users
+}
+
+func currentCode(t *testing.T) string {
+	t.Helper()
+
+	code, _, err := totp.CodeAt(testSecret, 6, totp.INTERVAL, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return code
+}
+
+// exchange send an Access-Request and return the response
+func exchange(t *testing.T, addr, name, password string) *radius.Packet {
+	t.Helper()
+
+	req := radius.New(radius.CodeAccessRequest, []byte(testSharedSecret))
+	rfc2865.UserName_SetString(req, name)
+	rfc2865.UserPassword_SetString(req, password)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	client := radius.Client{Retry: 0}
+	resp, err := client.Exchange(ctx, req, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return resp
+}
+
+func expectCode(t *testing.T, resp *radius.Packet, want radius.Code) {
+	t.Helper()
+
+	if resp.Code != want {
+		t.Fatalf("got %v, want %v", resp.Code, want)
+	}
+}
+
+func TestAcceptsPasswordAndCodeOnce(t *testing.T) {
+	addr := startServer(t, testUsers(t))
+	code := currentCode(t)
+
+	expectCode(t, exchange(t, addr, "alice", "hunter2"+code), radius.CodeAccessAccept)
+	expectCode(t, exchange(t, addr, "alice", "hunter2"+code), radius.CodeAccessReject)
+}
+
+func TestCodeOnlyUser(t *testing.T) {
+	addr := startServer(t, testUsers(t))
+	code := currentCode(t)
+
+	expectCode(t, exchange(t, addr, "bob", "hunter2"+code), radius.CodeAccessReject)
+	expectCode(t, exchange(t, addr, "bob", code), radius.CodeAccessAccept)
+}
+
+func TestRejectsUnknownAndPendingUsers(t *testing.T) {
+	addr := startServer(t, testUsers(t))
+	code := currentCode(t)
+
+	expectCode(t, exchange(t, addr, "mallory", code), radius.CodeAccessReject)
+	expectCode(t, exchange(t, addr, "carol", code), radius.CodeAccessReject)
+	expectCode(t, exchange(t, addr, "alice", "hunter2"), radius.CodeAccessReject)
+}
+
+func TestWrongPasswordKeepsCode(t *testing.T) {
+	addr := startServer(t, testUsers(t))
+	code := currentCode(t)
+
+	expectCode(t, exchange(t, addr, "alice", "wrong"+code), radius.CodeAccessReject)
+	expectCode(t, exchange(t, addr, "alice", "hunter2"+code), radius.CodeAccessAccept)
+}
+
+func TestWrongPasswordsLockOut(t *testing.T) {
+	addr := startServer(t, testUsers(t))
+	code := currentCode(t)
+
+	for i := 0; i < 5; i++ {
+		resp := exchange(t, addr, "alice", "guess"+code)
+		expectCode(t, resp, radius.CodeAccessReject)
+		if msg := rfc2865.ReplyMessage_GetString(resp); len(msg) > 0 {
+			t.Fatalf("attempt %d: locked too early: %s", i+1, msg)
+		}
+	}
+
+	resp := exchange(t, addr, "alice", "hunter2"+code)
+	expectCode(t, resp, radius.CodeAccessReject)
+	if msg := rfc2865.ReplyMessage_GetString(resp); len(msg) == 0 {
+		t.Fatal("locked out user got no reply message")
+	}
+
+	// other users are not affected
+	expectCode(t, exchange(t, addr, "bob", code), radius.CodeAccessAccept)
+}
+
+func TestActivationWhileRunning(t *testing.T) {
+	dir := t.TempDir()
+	path, state := filepath.Join(dir, "users.json"), filepath.Join(dir, "users.state.json")
+
+	// testUsers written to path
+	saved, err := enroll.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, u := range testUsers(t).Users {
+		saved.Add(name, u, false)
+	}
+	if err = saved.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	watched, err := enroll.Watch(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addr := startServerWith(t, watched, verifier.NewFileStore(state))
+	expectCode(t, exchange(t, addr, "carol", currentCode(t)), radius.CodeAccessReject)
+
+	// 'authy enroll activate' in another process, with the previous period's code
+	before := time.Now().Add(-totp.INTERVAL * time.Second)
+	first, _, err := totp.CodeAt(testSecret, 6, totp.INTERVAL, before)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	activating, err := enroll.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = activating.Activate("carol", first, verifier.NewFileStore(state), before); err != nil {
+		t.Fatal(err)
+	}
+	if err = activating.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the activation code is used up in the shared state
+	expectCode(t, exchange(t, addr, "carol", first), radius.CodeAccessReject)
+	expectCode(t, exchange(t, addr, "carol", currentCode(t)), radius.CodeAccessAccept)
+}
@@ -0,0 +1,413 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AgentSockEnv environment variable holding the agent socket path
+const AgentSockEnv = "AUTHY_AGENT_SOCK"
+
+// agent operations
+const (
+	agentOpFind    = "find"
+	agentOpResolve = "resolve"
+	agentOpStatus  = "status"
+	agentOpLock    = "lock"
+	agentOpUnlock  = "unlock"
+	agentOpStop    = "stop"
+)
+
+// agent error kinds, so clients can return the same errors as a local search
+const (
+	agentErrNotFound     = "not_found"
+	agentErrAmbiguous    = "ambiguous"
+	agentErrEmptySecret  = "empty_secret"
+	agentErrNoTokens     = "no_tokens"
+	agentErrInvalidQuery = "invalid_query"
+	agentErrLocked       = "locked"
+)
+
+// time a client gets to send its request and to read the response, codes may be
+// computed in between for as long as a wait for a fresh code takes
+const (
+	agentReadTimeout  = 5 * time.Second
+	agentWriteTimeout = 5 * time.Second
+)
+
+// ErrAgentLocked agent dropped its secrets after being idle or locked by hand
+var ErrAgentLocked = errors.New("Authy agent is locked, run 'authy agent unlock'")
+
+// agentRequest one request per connection
+type agentRequest struct {
+	Op      string      `json:"op"`
+	Request CodeRequest `json:"request,omitempty"`
+	Tokens  []*Token    `json:"tokens,omitempty"`
+}
+
+type agentResponse struct {
+	Outputs []agentOutput `json:"outputs,omitempty"`
+	Status  *AgentStatus  `json:"status,omitempty"`
+	Error   *agentError   `json:"error,omitempty"`
+}
+
+// agentOutput Output on the wire, the token never carries its secret
+type agentOutput struct {
+	Token      *Token `json:"token,omitempty"`
+	OTitle     string `json:"otitle,omitempty"`
+	Code       string `json:"code,omitempty"`
+	RemainSecs int    `json:"remain_secs,omitempty"`
+	Period     int    `json:"period,omitempty"`
+	NextCode   string `json:"next_code,omitempty"`
+	ValidIn    int    `json:"valid_in,omitempty"`
+	Highlights []int  `json:"highlights,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+type agentError struct {
+	Kind       string   `json:"kind,omitempty"`
+	Message    string   `json:"message"`
+	Query      string   `json:"query,omitempty"`
+	Candidates []*Token `json:"candidates,omitempty"`
+}
+
+// AgentStatus state of a running agent
+type AgentStatus struct {
+	Pid    int  `json:"pid"`
+	Locked bool `json:"locked"`
+	Tokens int  `json:"tokens"`
+	// IdleTimeout seconds without requests before locking, 0 never locks
+	IdleTimeout int `json:"idle_timeout"`
+}
+
+func withoutSecret(tk *Token) *Token {
+	if tk == nil {
+		return nil
+	}
+
+	cp := *tk
+	// keys of tokens without id are derived from the secret
+	cp.ID = tk.Key()
+	cp.Secret = ""
+	return &cp
+}
+
+func toAgentOutput(o Output) agentOutput {
+	ao := agentOutput{
+		Token:      withoutSecret(o.Token),
+		OTitle:     o.OTitle,
+		Code:       o.Code,
+		RemainSecs: o.RemainSecs,
+		Period:     o.Period,
+		NextCode:   o.NextCode,
+		ValidIn:    o.ValidIn,
+		Highlights: o.Highlights,
+	}
+
+	if o.Error != nil {
+		ao.Error = o.Error.Error()
+	}
+
+	return ao
+}
+
+func (ao agentOutput) output() Output {
+	o := Output{
+		Token:      ao.Token,
+		OTitle:     ao.OTitle,
+		Code:       ao.Code,
+		RemainSecs: ao.RemainSecs,
+		Period:     ao.Period,
+		NextCode:   ao.NextCode,
+		ValidIn:    ao.ValidIn,
+		Highlights: ao.Highlights,
+	}
+
+	switch ao.Error {
+	case "":
+	case ErrEmptySecret.Error():
+		o.Error = ErrEmptySecret
+	default:
+		o.Error = errors.New(ao.Error)
+	}
+
+	return o
+}
+
+func toAgentError(err error) *agentError {
+	if err == nil {
+		return nil
+	}
+
+	e := &agentError{Message: err.Error()}
+
+	var ambiguous *AmbiguousTokenError
+	switch {
+	case errors.Is(err, ErrTokenNotFound):
+		e.Kind = agentErrNotFound
+	case errors.As(err, &ambiguous):
+		e.Kind = agentErrAmbiguous
+		e.Query = ambiguous.Query
+		for _, tk := range ambiguous.Candidates {
+			e.Candidates = append(e.Candidates, withoutSecret(tk))
+		}
+	case errors.Is(err, ErrEmptySecret):
+		e.Kind = agentErrEmptySecret
+	case errors.Is(err, ErrNoTokens):
+		e.Kind = agentErrNoTokens
+	case errors.Is(err, ErrInvalidQuery):
+		e.Kind = agentErrInvalidQuery
+	case errors.Is(err, ErrAgentLocked):
+		e.Kind = agentErrLocked
+	}
+
+	return e
+}
+
+func (e *agentError) err() error {
+	if e == nil {
+		return nil
+	}
+
+	switch e.Kind {
+	case agentErrNotFound:
+		return ErrTokenNotFound
+	case agentErrAmbiguous:
+		return &AmbiguousTokenError{Query: e.Query, Candidates: e.Candidates}
+	case agentErrEmptySecret:
+		return ErrEmptySecret
+	case agentErrNoTokens:
+		return ErrNoTokens
+	case agentErrInvalidQuery:
+		return fmt.Errorf("%w: %s", ErrInvalidQuery, strings.TrimPrefix(e.Message, ErrInvalidQuery.Error()+": "))
+	case agentErrLocked:
+		return ErrAgentLocked
+	}
+
+	return errors.New(e.Message)
+}
+
+// Agent keeps decrypted tokens in memory only and serves codes over a Unix socket
+type Agent struct {
+	mu       sync.Mutex
+	tokens   []*Token
+	idle     time.Duration
+	timer    *time.Timer
+	listener net.Listener
+	path     string
+	// dir created for the socket, removed with it
+	dir string
+}
+
+// NewAgent agent unlocked with tokens, locking after idle without requests, 0 never locks
+func NewAgent(tokens []*Token, idle time.Duration) *Agent {
+	a := &Agent{
+		tokens: tokens,
+		idle:   idle,
+	}
+
+	if idle > 0 {
+		a.timer = time.AfterFunc(idle, a.lock)
+	}
+
+	return a
+}
+
+// Listen create the socket at path, only accessible by the current user,
+// an empty path creates it in a new private directory of the temp dir
+func (a *Agent) Listen(path string) error {
+	if len(path) == 0 {
+		dir, err := os.MkdirTemp("", "authy-")
+		if err != nil {
+			return err
+		}
+
+		a.dir = dir
+		path = filepath.Join(dir, "agent."+strconv.Itoa(os.Getpid()))
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	l, err := ListenUnix(path)
+	if err != nil {
+		return err
+	}
+
+	if !peerCredentials {
+		log.Printf("Warning: peer credentials can't be checked on %s, any process able to open %s is served", runtime.GOOS, path)
+	}
+
+	a.listener = l
+	a.path = path
+	return nil
+}
+
+// Path of the socket
+func (a *Agent) Path() string {
+	return a.path
+}
+
+// Serve handle requests until stopped
+func (a *Agent) Serve() error {
+	defer func() {
+		os.Remove(a.path)
+		if len(a.dir) > 0 {
+			os.Remove(a.dir)
+		}
+	}()
+
+	for {
+		conn, err := a.listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+
+		go a.handle(conn)
+	}
+}
+
+// Close stop serving, Serve removes the socket
+func (a *Agent) Close() error {
+	return a.listener.Close()
+}
+
+func (a *Agent) handle(conn net.Conn) {
+	defer conn.Close()
+
+	if err := checkPeer(conn); err != nil {
+		log.Println("Reject agent connection", err)
+		return
+	}
+
+	conn.SetReadDeadline(time.Now().Add(agentReadTimeout))
+
+	var req agentRequest
+	if err := json.NewDecoder(conn).Decode(&req); err != nil {
+		log.Println("Decode agent request failed", err)
+		return
+	}
+
+	resp := a.do(req)
+	conn.SetWriteDeadline(time.Now().Add(agentWriteTimeout))
+	if err := json.NewEncoder(conn).Encode(resp); err != nil {
+		log.Println("Encode agent response failed", err)
+	}
+
+	if req.Op == agentOpStop {
+		a.Close()
+	}
+}
+
+func (a *Agent) do(req agentRequest) (resp agentResponse) {
+	switch req.Op {
+	case agentOpStatus:
+		resp.Status = a.status()
+	case agentOpLock:
+		a.lock()
+	case agentOpUnlock:
+		a.unlock(req.Tokens)
+	case agentOpStop:
+		a.lock()
+	case agentOpFind:
+		d, err := a.device()
+		if err != nil {
+			resp.Error = toAgentError(err)
+			return
+		}
+
+		outputs, err := d.FindCodes(req.Request)
+		resp.Error = toAgentError(err)
+		for _, o := range outputs {
+			resp.Outputs = append(resp.Outputs, toAgentOutput(o))
+		}
+	case agentOpResolve:
+		d, err := a.device()
+		if err != nil {
+			resp.Error = toAgentError(err)
+			return
+		}
+
+		out, err := d.ResolveCode(req.Request)
+		resp.Error = toAgentError(err)
+		if err == nil {
+			resp.Outputs = []agentOutput{toAgentOutput(out)}
+		}
+	default:
+		resp.Error = &agentError{Message: fmt.Sprintf("Unknown agent operation %q", req.Op)}
+	}
+
+	return
+}
+
+// device snapshot of the tokens to search, so slow requests do not block others
+func (a *Agent) device() (*Device, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.tokens == nil {
+		return nil, ErrAgentLocked
+	}
+
+	a.touch()
+	return &Device{
+		conf: NewDeviceConfig{
+			ConfigFileName: configFileName,
+			CacheFileName:  cacheFileName,
+			UsageFileName:  usageFileName,
+		},
+		tokens: append([]*Token{}, a.tokens...),
+	}, nil
+}
+
+// touch restart the idle timer, must hold mu
+func (a *Agent) touch() {
+	if a.timer != nil {
+		a.timer.Reset(a.idle)
+	}
+}
+
+func (a *Agent) status() *AgentStatus {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return &AgentStatus{
+		Pid:         os.Getpid(),
+		Locked:      a.tokens == nil,
+		Tokens:      len(a.tokens),
+		IdleTimeout: int(a.idle / time.Second),
+	}
+}
+
+// lock drop all secrets
+func (a *Agent) lock() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.tokens = nil
+}
+
+func (a *Agent) unlock(tokens []*Token) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.tokens = tokens
+	if a.tokens == nil {
+		a.tokens = []*Token{}
+	}
+	a.touch()
+}
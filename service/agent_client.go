@@ -0,0 +1,108 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+)
+
+// ErrAgentUnreachable no agent listens on the configured socket
+var ErrAgentUnreachable = errors.New("Authy agent is not reachable")
+
+// AgentClient talks to a running agent
+type AgentClient struct {
+	path string
+}
+
+// NewAgentClient client of the agent listening on path
+func NewAgentClient(path string) *AgentClient {
+	return &AgentClient{path: path}
+}
+
+// AgentFromEnv client of the agent in AUTHY_AGENT_SOCK, nil if it is not set
+func AgentFromEnv() *AgentClient {
+	path := os.Getenv(AgentSockEnv)
+	if len(path) == 0 {
+		return nil
+	}
+
+	return NewAgentClient(path)
+}
+
+func (c *AgentClient) call(req agentRequest) (resp agentResponse, err error) {
+	conn, err := net.Dial("unix", c.path)
+	if err != nil {
+		return resp, fmt.Errorf("%w: %v", ErrAgentUnreachable, err)
+	}
+	defer conn.Close()
+
+	if err = json.NewEncoder(conn).Encode(req); err != nil {
+		return
+	}
+
+	if err = json.NewDecoder(conn).Decode(&resp); err != nil {
+		return resp, fmt.Errorf("Read agent response failed: %v", err)
+	}
+
+	return resp, resp.Error.err()
+}
+
+// FindCodes same as Device.FindCodes, computed by the agent
+func (c *AgentClient) FindCodes(req CodeRequest) ([]Output, error) {
+	resp, err := c.call(agentRequest{Op: agentOpFind, Request: req})
+	if err != nil {
+		return nil, err
+	}
+
+	outputs := make([]Output, 0, len(resp.Outputs))
+	for _, o := range resp.Outputs {
+		outputs = append(outputs, o.output())
+	}
+
+	return outputs, nil
+}
+
+// ResolveCode same as Device.ResolveCode, computed by the agent
+func (c *AgentClient) ResolveCode(req CodeRequest) (Output, error) {
+	resp, err := c.call(agentRequest{Op: agentOpResolve, Request: req})
+	if err != nil {
+		return Output{}, err
+	}
+
+	if len(resp.Outputs) == 0 {
+		return Output{}, ErrTokenNotFound
+	}
+
+	out := resp.Outputs[0].output()
+	return out, out.Error
+}
+
+// Status of the agent
+func (c *AgentClient) Status() (AgentStatus, error) {
+	resp, err := c.call(agentRequest{Op: agentOpStatus})
+	if err != nil || resp.Status == nil {
+		return AgentStatus{}, err
+	}
+
+	return *resp.Status, nil
+}
+
+// Lock make the agent drop its secrets
+func (c *AgentClient) Lock() error {
+	_, err := c.call(agentRequest{Op: agentOpLock})
+	return err
+}
+
+// Unlock hand decrypted tokens to the agent
+func (c *AgentClient) Unlock(tokens []*Token) error {
+	_, err := c.call(agentRequest{Op: agentOpUnlock, Tokens: tokens})
+	return err
+}
+
+// Stop make the agent drop its secrets and exit
+func (c *AgentClient) Stop() error {
+	_, err := c.call(agentRequest{Op: agentOpStop})
+	return err
+}
@@ -0,0 +1,159 @@
+package service
+
+import (
+	"context"
+	"encoding/base32"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alexzorin/authy"
+	"github.com/momaek/authy/totp"
+)
+
+// APIURLEnv base URL of the Authy API, e.g. a fake server when testing
+const APIURLEnv = "AUTHY_API_URL"
+
+const (
+	defaultAPIURL = "https://api.authy.com/json/"
+	// device requests are authenticated with codes of the device seed
+	deviceOTPDigits = 7
+	deviceOTPPeriod = 10
+)
+
+// apiTimeout limit of a whole device request
+const apiTimeout = 15 * time.Second
+
+// apiClient client of the device requests, sent to AUTHY_API_URL when set
+var apiClient = &http.Client{
+	Timeout:   apiTimeout,
+	Transport: apiRewriter{next: http.DefaultTransport},
+}
+
+// apiURL base URL of the Authy API, ending with a slash
+func apiURL() string {
+	u := os.Getenv(APIURLEnv)
+	if len(u) == 0 {
+		return defaultAPIURL
+	}
+
+	return strings.TrimSuffix(u, "/") + "/"
+}
+
+// apiRewriter send requests to the default Authy API to AUTHY_API_URL instead
+type apiRewriter struct {
+	next http.RoundTripper
+}
+
+func (a apiRewriter) RoundTrip(req *http.Request) (*http.Response, error) {
+	if apiURL() == defaultAPIURL {
+		return a.next.RoundTrip(req)
+	}
+
+	base, err := url.Parse(apiURL())
+	if err != nil {
+		return nil, fmt.Errorf("Invalid %s: %v", APIURLEnv, err)
+	}
+
+	if prefix := "/json/"; req.URL.Host == "api.authy.com" && strings.HasPrefix(req.URL.Path, prefix) {
+		req = req.Clone(req.Context())
+		req.URL.Scheme = base.Scheme
+		req.URL.Host = base.Host
+		req.URL.Path = base.Path + strings.TrimPrefix(req.URL.Path, prefix)
+		req.Host = ""
+	}
+
+	return a.next.RoundTrip(req)
+}
+
+// apiResponse common fields of Authy API responses
+type apiResponse struct {
+	// StatusCode of the HTTP response
+	StatusCode int    `json:"-"`
+	Message    string `json:"message"`
+	Success    bool   `json:"success"`
+	ErrorCode  string `json:"error_code"`
+}
+
+// deviceRequest API request authenticated as the registered device, with the api_key,
+// device_id and otp1..3 form fields github.com/alexzorin/authy sends. The library's
+// client can't be pointed at AUTHY_API_URL, so its requests are rebuilt here
+func (d *Device) deviceRequest(ctx context.Context, method, path string, form url.Values) (resp apiResponse, err error) {
+	codes, err := deviceOTPs(d.registration.Seed, time.Now())
+	if err != nil {
+		return resp, fmt.Errorf("Failed to generate TOTP codes: %v", err)
+	}
+
+	if form == nil {
+		form = url.Values{}
+	}
+
+	form.Set("api_key", authyAPIKey())
+	form.Set("device_id", strconv.FormatUint(d.registration.DeviceID, 10))
+	for i, code := range codes {
+		form.Set(fmt.Sprintf("otp%d", i+1), code)
+	}
+
+	u := defaultAPIURL + path
+	var req *http.Request
+	if method == http.MethodGet {
+		req, err = http.NewRequestWithContext(ctx, method, u+"?"+form.Encode(), nil)
+	} else {
+		req, err = http.NewRequestWithContext(ctx, method, u, strings.NewReader(form.Encode()))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+	}
+
+	if err != nil {
+		return
+	}
+
+	res, err := apiClient.Do(req)
+	if err != nil {
+		return
+	}
+	defer res.Body.Close()
+
+	resp.StatusCode = res.StatusCode
+	if err = json.NewDecoder(res.Body).Decode(&resp); err != nil {
+		return resp, fmt.Errorf("Unexpected response from Authy, HTTP %d: %v", res.StatusCode, err)
+	}
+
+	return resp, nil
+}
+
+// deviceOTPs three consecutive codes of the hex encoded device seed
+func deviceOTPs(seed string, t time.Time) (codes [3]string, err error) {
+	raw, err := hex.DecodeString(seed)
+	if err != nil {
+		return
+	}
+
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(raw)
+	for i := range codes {
+		if codes[i], _, err = totp.CodeAt(secret, deviceOTPDigits, deviceOTPPeriod, t); err != nil {
+			return
+		}
+
+		t = t.Add(deviceOTPPeriod * time.Second)
+	}
+
+	return
+}
+
+// authyAPIKey the API key the client sends
+func authyAPIKey() string {
+	client, err := authy.NewClient()
+	if err != nil {
+		return ""
+	}
+
+	return client.APIKey
+}
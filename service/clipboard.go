@@ -0,0 +1,262 @@
+package service
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Clipboard modes
+const (
+	// ClipboardAuto OSC 52 in SSH sessions, otherwise a helper command if one is installed
+	ClipboardAuto = "auto"
+	// ClipboardOSC52 terminal escape sequence, works over SSH and in tmux
+	ClipboardOSC52 = "osc52"
+	// ClipboardHelper configured or platform helper command
+	ClipboardHelper = "helper"
+)
+
+// clipboardCommands helpers tried in order, the first one installed is used
+var clipboardCommands = map[string][][]string{
+	"darwin":  {{"pbcopy"}},
+	"windows": {{"clip"}},
+	"linux":   {{"wl-copy"}, {"xclip", "-selection", "clipboard"}, {"xsel", "--clipboard", "--input"}},
+}
+
+// pasteCommands commands reading the clipboard, keyed by the copy helper they go with
+var pasteCommands = map[string][]string{
+	"pbcopy":  {"pbpaste"},
+	"clip":    {"powershell", "-NoProfile", "-Command", "Get-Clipboard"},
+	"wl-copy": {"wl-paste", "--no-newline"},
+	"xclip":   {"xclip", "-selection", "clipboard", "-out"},
+	"xsel":    {"xsel", "--clipboard", "--output"},
+}
+
+// ErrNoClipboard no clipboard helper found
+var ErrNoClipboard = errors.New("No clipboard helper found, install pbcopy, wl-copy, xclip or xsel, or set AUTHY_CLIPBOARD_CMD")
+
+// ClipboardConfig how codes are put on the clipboard
+type ClipboardConfig struct {
+	Mode string
+	// Command helper command line reading the text from stdin, e.g. "xclip -selection clipboard"
+	Command string
+	// ClearAfter clear the clipboard after this long, 0 clears when the code expires
+	ClearAfter time.Duration
+}
+
+// ClipboardConfigFromEnv config from AUTHY_CLIPBOARD, AUTHY_CLIPBOARD_CMD and
+// AUTHY_CLIPBOARD_CLEAR (seconds)
+func ClipboardConfigFromEnv() ClipboardConfig {
+	c := ClipboardConfig{
+		Mode:    os.Getenv("AUTHY_CLIPBOARD"),
+		Command: os.Getenv("AUTHY_CLIPBOARD_CMD"),
+	}
+
+	if secs, err := strconv.Atoi(os.Getenv("AUTHY_CLIPBOARD_CLEAR")); err == nil {
+		c.ClearAfter = time.Duration(secs) * time.Second
+	}
+
+	return c
+}
+
+func (c ClipboardConfig) mode() string {
+	switch c.Mode {
+	case ClipboardOSC52, ClipboardHelper:
+		return c.Mode
+	}
+
+	if len(c.Command) > 0 {
+		return ClipboardHelper
+	}
+
+	if len(os.Getenv("SSH_TTY")) > 0 || len(os.Getenv("SSH_CONNECTION")) > 0 {
+		return ClipboardOSC52
+	}
+
+	if c.helper() != nil {
+		return ClipboardHelper
+	}
+
+	return ClipboardOSC52
+}
+
+func (c ClipboardConfig) helper() []string {
+	if len(c.Command) > 0 {
+		return strings.Fields(c.Command)
+	}
+
+	for _, args := range clipboardCommands[runtime.GOOS] {
+		if _, err := exec.LookPath(args[0]); err == nil {
+			return args
+		}
+	}
+
+	return nil
+}
+
+// Copy put text on the clipboard, empty text clears it
+func (c ClipboardConfig) Copy(text string) error {
+	if c.mode() == ClipboardOSC52 {
+		return writeOSC52(text)
+	}
+
+	args := c.helper()
+	if args == nil {
+		return ErrNoClipboard
+	}
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin = strings.NewReader(text)
+	return cmd.Run()
+}
+
+// errUnreadableClipboard the clipboard can't be read back, OSC 52 is write only
+var errUnreadableClipboard = errors.New("Clipboard can't be read")
+
+// paste text on the clipboard
+func (c ClipboardConfig) paste() (string, error) {
+	if c.mode() == ClipboardOSC52 {
+		return "", errUnreadableClipboard
+	}
+
+	args := c.helper()
+	if args == nil {
+		return "", ErrNoClipboard
+	}
+
+	paste, ok := pasteCommands[filepath.Base(args[0])]
+	if !ok {
+		return "", errUnreadableClipboard
+	}
+
+	b, err := exec.Command(paste[0], paste[1:]...).Output()
+	if err != nil {
+		return "", err
+	}
+
+	return string(bytes.TrimRight(b, "\r\n")), nil
+}
+
+// ClipboardHash hash of a copied text, handed to the clearing process instead of the text
+func ClipboardHash(text string) string {
+	sum := sha256.Sum256([]byte(text))
+	return hex.EncodeToString(sum[:])
+}
+
+// ClearIfHolds clear the clipboard if it still holds the text hashed to hash, something
+// copied since is left alone. A clipboard that can't be read back, or an empty hash,
+// always clears
+func (c ClipboardConfig) ClearIfHolds(hash string) error {
+	if len(hash) == 0 {
+		return c.Copy("")
+	}
+
+	text, err := c.paste()
+	switch {
+	case errors.Is(err, errUnreadableClipboard):
+	case err != nil:
+		return err
+	case ClipboardHash(text) != hash:
+		return nil
+	}
+
+	return c.Copy("")
+}
+
+// writeOSC52 set the clipboard of the terminal emulator, passed through tmux when running inside it
+func writeOSC52(text string) error {
+	payload := base64.StdEncoding.EncodeToString([]byte(text))
+	if len(text) == 0 {
+		// not valid base64, terminals clear the selection
+		payload = "!"
+	}
+
+	seq := "\033]52;c;" + payload + "\a"
+	if len(os.Getenv("TMUX")) > 0 {
+		seq = "\033Ptmux;\033" + seq + "\033\\"
+	}
+
+	var w io.Writer = os.Stderr
+	if tty, err := os.OpenFile("/dev/tty", os.O_WRONLY, 0); err == nil {
+		defer tty.Close()
+		w = tty
+	}
+
+	_, err := io.WriteString(w, seq)
+	return err
+}
+
+// ScheduleClear start a detached process that clears the clipboard after d if it still
+// holds text. Its arguments only hold the clipboard settings, the hash of the text is
+// written to its stdin so other users can't read it from the process list
+func (c ClipboardConfig) ScheduleClear(text string, d time.Duration) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command(exe, "clipboard-clear",
+		"--after", strconv.Itoa(int(d/time.Second)),
+		"--clipboard", c.mode(),
+		"--clipboard-cmd", c.Command,
+		"--hash-stdin",
+	)
+	// keeps the terminal for OSC 52
+	cmd.Stderr = os.Stderr
+	Detach(cmd)
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+
+	if err = cmd.Start(); err != nil {
+		return err
+	}
+
+	// without the hash the clipboard is cleared unconditionally
+	_, err = io.WriteString(stdin, ClipboardHash(text)+"\n")
+	stdin.Close()
+	if err != nil {
+		return err
+	}
+
+	return cmd.Process.Release()
+}
+
+// CopyCode copy the code of o, clear it once expired and record the use
+func (d *Device) CopyCode(o Output, c ClipboardConfig) error {
+	if o.Error != nil {
+		return o.Error
+	}
+
+	if err := c.Copy(o.Code); err != nil {
+		return err
+	}
+
+	after := c.ClearAfter
+	if after <= 0 {
+		after = time.Duration(o.RemainSecs) * time.Second
+	}
+
+	if err := c.ScheduleClear(o.Code, after); err != nil {
+		return fmt.Errorf("Schedule clearing clipboard failed: %v", err)
+	}
+
+	if o.Token != nil {
+		d.RecordUsage(o.Token)
+	}
+
+	return nil
+}
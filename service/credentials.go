@@ -0,0 +1,179 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// Environment variables read when the matching NewDeviceConfig field is empty
+const (
+	CountryCodeEnv     = "AUTHY_COUNTRY_CODE"
+	MobileEnv          = "AUTHY_MOBILE"
+	PasswordEnv        = "AUTHY_PASSWORD"
+	PasswordFileEnv    = "AUTHY_PASSWORD_FILE"
+	PasswordCommandEnv = "AUTHY_PASSWORD_COMMAND"
+)
+
+// ErrNoTTY input is required but stdin is not a terminal
+var ErrNoTTY = errors.New("stdin is not a terminal")
+
+// fromEnv fill empty credential fields of conf from the environment
+func (conf *NewDeviceConfig) fromEnv() {
+	fields := []struct {
+		value *string
+		env   string
+	}{
+		{&conf.CountryCode, CountryCodeEnv},
+		{&conf.Mobile, MobileEnv},
+		{&conf.Password, PasswordEnv},
+		{&conf.PasswordFile, PasswordFileEnv},
+		{&conf.PasswordCommand, PasswordCommandEnv},
+		{&conf.Via, RegisterViaEnv},
+	}
+
+	for _, f := range fields {
+		if len(*f.value) == 0 {
+			*f.value = os.Getenv(f.env)
+		}
+	}
+}
+
+// hasPassword backup password given by flag, environment, file or command
+func (conf NewDeviceConfig) hasPassword() bool {
+	return len(conf.Password) > 0 || len(conf.PasswordFile) > 0 || len(conf.PasswordCommand) > 0
+}
+
+// password backup password from Password, the first line of PasswordFile or
+// of the output of PasswordCommand, e.g. "pass show authy", run by the shell
+func (conf NewDeviceConfig) password() (string, error) {
+	switch {
+	case len(conf.Password) > 0:
+		return conf.Password, nil
+	case len(conf.PasswordFile) > 0:
+		b, err := os.ReadFile(conf.PasswordFile)
+		if err != nil {
+			return "", fmt.Errorf("Read password file failed: %w", err)
+		}
+
+		return firstLine(b), nil
+	case len(conf.PasswordCommand) > 0:
+		cmd := shellCommand(conf.PasswordCommand)
+		// pinentry and friends may still need the terminal
+		cmd.Stdin = os.Stdin
+		cmd.Stderr = os.Stderr
+
+		b, err := cmd.Output()
+		if err != nil {
+			return "", fmt.Errorf("Password command %q failed: %w", conf.PasswordCommand, err)
+		}
+
+		if pwd := firstLine(b); len(pwd) > 0 {
+			return pwd, nil
+		}
+
+		return "", fmt.Errorf("Password command %q printed nothing", conf.PasswordCommand)
+	}
+
+	return "", nil
+}
+
+// shellCommand line run by sh -c, or cmd /C on Windows, so quotes, pipes and
+// variables work like on the command line
+func shellCommand(line string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.Command("cmd", "/C", line)
+	}
+
+	return exec.Command("sh", "-c", line)
+}
+
+func firstLine(b []byte) string {
+	if i := bytes.IndexByte(b, '\n'); i >= 0 {
+		b = b[:i]
+	}
+
+	return strings.TrimSpace(string(b))
+}
+
+func stdinIsTerminal() bool {
+	return terminal.IsTerminal(int(os.Stdin.Fd()))
+}
+
+// promptLine ask a question on the terminal, hint tells how to provide the answer without one
+func promptLine(ctx context.Context, question, hint string) (string, error) {
+	if !stdinIsTerminal() {
+		return "", fmt.Errorf("%w, %s", ErrNoTTY, hint)
+	}
+
+	fmt.Print(question)
+	line, err := readLine(ctx)
+	if errors.Is(err, io.EOF) {
+		return "", fmt.Errorf("No input, %s", hint)
+	}
+	if err != nil {
+		fmt.Println()
+		return "", err
+	}
+
+	return strings.TrimSpace(line), nil
+}
+
+var (
+	stdinOnce  sync.Once
+	stdinLines chan string
+)
+
+// readLine next line of stdin, or the context error once ctx is done. Stdin is read
+// by one goroutine for all prompts, so a cancelled read leaves no reader behind
+// that would swallow the next line
+func readLine(ctx context.Context) (string, error) {
+	stdinOnce.Do(func() {
+		stdinLines = make(chan string)
+		go func() {
+			defer close(stdinLines)
+
+			sc := bufio.NewScanner(os.Stdin)
+			for sc.Scan() {
+				stdinLines <- sc.Text()
+			}
+		}()
+	})
+
+	select {
+	case <-ctx.Done():
+		return "", ctx.Err()
+	case line, ok := <-stdinLines:
+		if !ok {
+			return "", io.EOF
+		}
+
+		return line, nil
+	}
+}
+
+// promptPassword read the backup password from the terminal without echo
+func promptPassword() (string, error) {
+	if !stdinIsTerminal() {
+		return "", fmt.Errorf("%w, set --password-command, --password-file or %s", ErrNoTTY, PasswordCommandEnv)
+	}
+
+	fmt.Print("\nPlease input Authy main password: ")
+	pp, err := terminal.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Println()
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(pp)), nil
+}
@@ -0,0 +1,13 @@
+//go:build !windows
+
+package service
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// Detach run cmd in its own session so it outlives the terminal command
+func Detach(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+}
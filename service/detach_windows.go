@@ -0,0 +1,15 @@
+//go:build windows
+
+package service
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+const detachedProcess = 0x00000008
+
+// Detach run cmd without a console so it outlives the terminal command
+func Detach(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{CreationFlags: detachedProcess}
+}
@@ -1,15 +1,16 @@
 package service
 
 import (
-	"bufio"
+	"context"
 	"encoding/json"
 	"errors"
-	"fmt"
 	"log"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/alexzorin/authy"
@@ -28,6 +29,8 @@ type DeviceRegistration struct {
 	Seed         string `json:"seed,omitempty"`
 	APIKey       string `json:"api_key,omitempty"`
 	MainPassword string `json:"main_password,omitempty"`
+	// Revoked Authy rejected the credentials, see Device.Revoked
+	Revoked bool `json:"revoked,omitempty"`
 }
 
 // NewDeviceConfig new device config
@@ -35,10 +38,22 @@ type NewDeviceConfig struct {
 	CountryCode string
 	Mobile      string
 	Password    string
+	// PasswordFile file whose first line is the backup password
+	PasswordFile string
+	// PasswordCommand shell command printing the backup password, e.g. "pass show authy"
+	PasswordCommand string
+
+	// Via registration method, push (default), sms or call
+	Via string
+	// RegisterTimeout how long to wait for the registration to be accepted, default 5m
+	RegisterTimeout time.Duration
+	// PollInterval first interval between registration status checks, default 5s
+	PollInterval time.Duration
 
 	ConfigFilePath string
 	ConfigFileName string
 	CacheFileName  string
+	UsageFileName  string
 }
 
 // Device ..
@@ -47,10 +62,43 @@ type Device struct {
 	registration DeviceRegistration
 	tokenMap     map[string]*Token
 	tokens       []*Token
+	usage        UsageStore
 }
 
 // NewDevice ..
 func NewDevice(conf NewDeviceConfig) *Device {
+	d := newDevice(conf)
+
+	d.RegisterOrGetDeviceInfo()
+
+	return d
+}
+
+// Registration ids and credentials of the registered device, the seed and API key
+// are secrets, don't print them
+func (d *Device) Registration() DeviceRegistration {
+	return d.registration
+}
+
+// LoadDevice device with its existing registration, ErrNotRegistered when there is none.
+// Unlike NewDevice it never starts a registration
+func LoadDevice(conf NewDeviceConfig) (*Device, error) {
+	d := newDevice(conf)
+
+	devInfo, err := d.LoadExistingDeviceInfo()
+	if os.IsNotExist(err) || (err == nil && devInfo.UserID == 0) {
+		return nil, ErrNotRegistered
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	d.registration = devInfo
+	return d, nil
+}
+
+func newDevice(conf NewDeviceConfig) *Device {
 	if len(conf.ConfigFileName) == 0 {
 		conf.ConfigFileName = configFileName
 	}
@@ -59,16 +107,27 @@ func NewDevice(conf NewDeviceConfig) *Device {
 		conf.CacheFileName = cacheFileName
 	}
 
-	d := &Device{
-		conf: conf,
+	if len(conf.UsageFileName) == 0 {
+		conf.UsageFileName = usageFileName
 	}
 
-	d.RegisterOrGetDeviceInfo()
+	if conf.RegisterTimeout <= 0 {
+		conf.RegisterTimeout = defaultRegisterTimeout
+	}
 
-	return d
+	if conf.PollInterval <= 0 {
+		conf.PollInterval = defaultPollInterval
+	}
+
+	conf.fromEnv()
+
+	return &Device{
+		conf: conf,
+	}
 }
 
-// RegisterOrGetDeviceInfo get device info from local cache, if not exist register a new device
+// RegisterOrGetDeviceInfo get device info from local cache, if not exist register a new device.
+// A new registration is returned as well, so the command goes on with it
 func (d *Device) RegisterOrGetDeviceInfo() (devInfo DeviceRegistration) {
 	devInfo, err := d.LoadExistingDeviceInfo()
 	if err == nil && devInfo.UserID != 0 {
@@ -79,14 +138,17 @@ func (d *Device) RegisterOrGetDeviceInfo() (devInfo DeviceRegistration) {
 	err = os.ErrNotExist
 
 	if os.IsNotExist(err) {
-		devInfo, err = d.newRegistrationDevice()
+		// Ctrl-C cancels the registration cleanly
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		devInfo, err = d.newRegistrationDevice(ctx)
+		stop()
 		if err != nil {
 			os.Exit(1)
 		}
 
 		log.Println("Register device successfully!!!")
 		log.Printf("Your device id: %v\n", devInfo.DeviceID)
-		os.Exit(0)
+		return
 	}
 
 	if err != nil {
@@ -96,21 +158,16 @@ func (d *Device) RegisterOrGetDeviceInfo() (devInfo DeviceRegistration) {
 	return
 }
 
-func getCountryCodeAndMobile(countrycode, mobile string) (int, string, error) {
-	var (
-		sc      = bufio.NewScanner(os.Stdin)
-		codeInt int
-	)
-
+// getCountryCodeAndMobile from the config, else asked on the terminal until ctx is done
+func getCountryCodeAndMobile(ctx context.Context, countrycode, mobile string) (int, string, error) {
+	var err error
 	if len(countrycode) == 0 {
-		fmt.Print("\nWhat is your phone number's country code? (digits only, e.g. 86): ")
-		if !sc.Scan() {
-			err := errors.New("Please provide a phone country code, e.g. 86")
+		countrycode, err = promptLine(ctx, "\nWhat is your phone number's country code? (digits only, e.g. 86): ",
+			"provide a phone country code with --countrycode or "+CountryCodeEnv)
+		if err != nil {
 			log.Println(err)
 			return 0, "", err
 		}
-
-		countrycode = sc.Text()
 	}
 
 	codeInt, err := strconv.Atoi(strings.TrimSpace(countrycode))
@@ -120,14 +177,12 @@ func getCountryCodeAndMobile(countrycode, mobile string) (int, string, error) {
 	}
 
 	if len(mobile) == 0 {
-		fmt.Print("\nWhat is your phone number? (digits only): ")
-		if !sc.Scan() {
-			err = errors.New("Please provide a phone number, e.g. 1232211")
+		mobile, err = promptLine(ctx, "\nWhat is your phone number? (digits only): ",
+			"provide a phone number with --mobilenumber or "+MobileEnv)
+		if err != nil {
 			log.Println(err)
 			return 0, "", err
 		}
-
-		mobile = sc.Text()
 	}
 
 	mobile = strings.TrimSpace(mobile)
@@ -135,77 +190,31 @@ func getCountryCodeAndMobile(countrycode, mobile string) (int, string, error) {
 	return codeInt, mobile, nil
 }
 
-func registerDevice(client authy.Client, userStatus authy.UserStatus) (resp authy.CompleteDeviceRegistrationResponse, err error) {
-	// Begin a device registration using Authy app push notification
-	regStart, err := client.RequestDeviceRegistration(nil, userStatus.AuthyID, authy.ViaMethodPush)
+func (d *Device) newRegistrationDevice(ctx context.Context) (devInfo DeviceRegistration, err error) {
+	via, err := checkRegistrationMethod(d.conf.Via)
 	if err != nil {
-		log.Println("Start register device failed", err)
-		return
-	}
-
-	if !regStart.Success {
-		err = fmt.Errorf("Authy did not accept the device registration request: %+v", regStart)
 		log.Println(err)
 		return
 	}
 
-	var regPIN string
-	timeout := time.Now().Add(5 * time.Minute)
-	for {
-		if timeout.Before(time.Now()) {
-			err = errors.New("Gave up waiting for user to respond to Authy device registration request")
-			log.Println(err)
-			return
-		}
-
-		log.Printf("Checking device registration status (%s until we give up)", time.Until(timeout).Truncate(time.Second))
-
-		regStatus, err1 := client.CheckDeviceRegistration(nil, userStatus.AuthyID, regStart.RequestID)
-		if err1 != nil {
-			err = err1
-			log.Println(err)
-			return
-		}
-		if regStatus.Status == "accepted" {
-			regPIN = regStatus.PIN
-			break
-		} else if regStatus.Status != "pending" {
-			err = fmt.Errorf("Invalid status while waiting for device registration: %s", regStatus.Status)
-			log.Println(err)
-			return
-		}
-
-		time.Sleep(5 * time.Second)
-	}
-
-	resp, err = client.CompleteDeviceRegistration(nil, userStatus.AuthyID, regPIN)
+	codeInt, mobile, err := getCountryCodeAndMobile(ctx, d.conf.CountryCode, d.conf.Mobile)
 	if err != nil {
-		log.Println(err)
-		return
-	}
-
-	if resp.Device.SecretSeed == "" {
-		err = errors.New("Something went wrong completing the device registration")
-		log.Println(err)
 		return
 	}
 
-	return
-}
-
-func (d *Device) newRegistrationDevice() (devInfo DeviceRegistration, err error) {
-
-	codeInt, mobile, err := getCountryCodeAndMobile(d.conf.CountryCode, d.conf.Mobile)
-
 	client, err := authy.NewClient()
 	if err != nil {
 		log.Println("New authy client failed", err)
 		return
 	}
 
-	userStatus, err := client.QueryUser(nil, codeInt, mobile)
+	// the timeout covers every request, starting with the user lookup
+	ctx, cancel := context.WithTimeout(ctx, d.conf.RegisterTimeout)
+	defer cancel()
+
+	userStatus, err := client.QueryUser(ctx, codeInt, mobile)
 	if err != nil {
-		log.Println("Query user failed", err)
+		log.Println("Query user failed", registrationError(ctx, err))
 		return
 	}
 
@@ -215,13 +224,18 @@ func (d *Device) newRegistrationDevice() (devInfo DeviceRegistration, err error)
 		return
 	}
 
-	resp, err := registerDevice(client, userStatus)
+	resp, err := registerDevice(ctx, client, userStatus, via, d.conf)
+	if err != nil {
+		return
+	}
 
 	devInfo = DeviceRegistration{
 		UserID:   resp.AuthyID,
 		DeviceID: resp.Device.ID,
 		Seed:     resp.Device.SecretSeed,
 		APIKey:   resp.Device.APIKey,
+		// kept when registering again
+		MainPassword: d.registration.MainPassword,
 	}
 
 	d.registration = devInfo
@@ -236,7 +250,7 @@ func (d *Device) newRegistrationDevice() (devInfo DeviceRegistration, err error)
 
 // SaveDeviceInfo ..
 func (d *Device) SaveDeviceInfo() (err error) {
-	regrPath, err := d.ConfigPath(configFileName)
+	regrPath, err := d.ConfigPath(d.conf.ConfigFileName)
 	if err != nil {
 		return
 	}
@@ -253,7 +267,7 @@ func (d *Device) SaveDeviceInfo() (err error) {
 
 // LoadExistingDeviceInfo ...
 func (d *Device) LoadExistingDeviceInfo() (devInfo DeviceRegistration, err error) {
-	devPath, err := d.ConfigPath(configFileName)
+	devPath, err := d.ConfigPath(d.conf.ConfigFileName)
 	if err != nil {
 		log.Println("Get device info file path failed", err)
 		os.Exit(1)
@@ -277,7 +291,7 @@ func (d *Device) ConfigPath(fname string) (string, error) {
 		authy_root_path, root_dir_exists := os.LookupEnv("AUTHY_ROOT")
 		devPath, err := homedir.Dir()
 		if root_dir_exists {
-			devPath = authy_root_path 
+			devPath = authy_root_path
 			d.conf.ConfigFilePath = devPath
 		} else {
 			if err != nil {
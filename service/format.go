@@ -0,0 +1,171 @@
+package service
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// Record machine readable search result
+type Record struct {
+	ID         string   `json:"id,omitempty"`
+	Title      string   `json:"title"`
+	Issuer     string   `json:"issuer,omitempty"`
+	Account    string   `json:"account,omitempty"`
+	Tags       []string `json:"tags,omitempty"`
+	Code       string   `json:"code,omitempty"`
+	RemainSecs int      `json:"remain_secs"`
+	Period     int      `json:"period"`
+	NextCode   string   `json:"next_code,omitempty"`
+	ValidIn    int      `json:"valid_in,omitempty"`
+	Error      string   `json:"error,omitempty"`
+}
+
+// Record to machine readable result
+func (o Output) Record() Record {
+	r := Record{
+		Title:      o.Title(),
+		Code:       o.Code,
+		RemainSecs: o.RemainSecs,
+		Period:     o.Period,
+		NextCode:   o.NextCode,
+		ValidIn:    o.ValidIn,
+	}
+
+	if o.Token != nil {
+		r.ID = o.Token.Key()
+		r.Issuer = o.Token.Issuer
+		r.Account = o.Token.Account
+		r.Tags = o.Token.Tags
+	}
+
+	if o.Error != nil {
+		r.Error = o.Error.Error()
+	}
+
+	return r
+}
+
+func records(outputs []Output) []Record {
+	ret := make([]Record, 0, len(outputs))
+	for _, o := range outputs {
+		ret = append(ret, o.Record())
+	}
+
+	return ret
+}
+
+type jsonRenderer struct{}
+
+func (jsonRenderer) Render(w io.Writer, outputs []Output) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(records(outputs))
+}
+
+type jsonlRenderer struct{}
+
+func (jsonlRenderer) Render(w io.Writer, outputs []Output) error {
+	enc := json.NewEncoder(w)
+	for _, r := range records(outputs) {
+		if err := enc.Encode(r); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+var delimitedHeader = []string{"id", "title", "issuer", "account", "tags", "code", "remain_secs", "period", "error", "next_code", "valid_in"}
+
+type delimitedRenderer struct {
+	comma rune
+}
+
+func (d delimitedRenderer) Render(w io.Writer, outputs []Output) error {
+	cw := csv.NewWriter(w)
+	cw.Comma = d.comma
+	if err := cw.Write(delimitedHeader); err != nil {
+		return err
+	}
+
+	for _, r := range records(outputs) {
+		err := cw.Write([]string{
+			r.ID,
+			r.Title,
+			r.Issuer,
+			r.Account,
+			strings.Join(r.Tags, ","),
+			r.Code,
+			strconv.Itoa(r.RemainSecs),
+			strconv.Itoa(r.Period),
+			r.Error,
+			r.NextCode,
+			strconv.Itoa(r.ValidIn),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// plainRenderer one uncolored line per result
+type plainRenderer struct{}
+
+func (plainRenderer) Render(w io.Writer, outputs []Output) error {
+	for _, r := range records(outputs) {
+		var err error
+		if len(r.Error) > 0 {
+			_, err = fmt.Fprintf(w, "%s: error: %s\n", r.Title, r.Error)
+		} else if r.ValidIn > 0 {
+			_, err = fmt.Fprintf(w, "%s: %s (valid in %ds)\n", r.Title, r.Code, r.ValidIn)
+		} else {
+			_, err = fmt.Fprintf(w, "%s: %s (%ds)\n", r.Title, r.Code, r.RemainSecs)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// templateRenderer execute template for every Record, each followed by a newline
+type templateRenderer struct {
+	tmpl *template.Template
+}
+
+func newTemplateRenderer(text string) (Renderer, error) {
+	if len(text) == 0 {
+		return nil, fmt.Errorf("Output format %s needs a template", FormatTemplate)
+	}
+
+	tmpl, err := template.New("output").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid template: %v", err)
+	}
+
+	return templateRenderer{tmpl: tmpl}, nil
+}
+
+func (t templateRenderer) Render(w io.Writer, outputs []Output) error {
+	for _, r := range records(outputs) {
+		if err := t.tmpl.Execute(w, r); err != nil {
+			return err
+		}
+
+		if _, err := fmt.Fprintln(w); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,62 @@
+package service
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// maxFreshWaits tokens with different periods may need more than one wait
+const maxFreshWaits = 3
+
+// effectiveMinRemaining a code never has more than period seconds left
+func effectiveMinRemaining(minRemaining, period int) int {
+	if minRemaining >= period {
+		return period - 1
+	}
+
+	return minRemaining
+}
+
+// freshWait how long to wait until tk has at least minRemaining seconds left
+func freshWait(tk *Token, now time.Time, minRemaining int) time.Duration {
+	period := int64(tk.PeriodSecs())
+	remain := period - now.Unix()%period
+	if remain >= int64(effectiveMinRemaining(minRemaining, int(period))) {
+		return 0
+	}
+
+	next := time.Unix((now.Unix()/period+1)*period, 0)
+	return next.Sub(now)
+}
+
+// WaitForFresh sleep until the codes of all tokens have at least minRemaining seconds left
+func WaitForFresh(tokens []*Token, minRemaining int) {
+	if minRemaining <= 0 {
+		return
+	}
+
+	for i := 0; i < maxFreshWaits; i++ {
+		var (
+			now  = time.Now()
+			wait time.Duration
+		)
+
+		for _, tk := range tokens {
+			if len(tk.Secret) == 0 {
+				continue
+			}
+
+			if w := freshWait(tk, now, minRemaining); w > wait {
+				wait = w
+			}
+		}
+
+		if wait == 0 {
+			return
+		}
+
+		fmt.Fprintf(os.Stderr, "Waiting %s for a fresh code\n", wait.Round(time.Second))
+		time.Sleep(wait)
+	}
+}
@@ -0,0 +1,177 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Launcher output formats
+const (
+	FormatRofi      = "rofi"
+	FormatDmenu     = "dmenu"
+	FormatFzf       = "fzf"
+	FormatUlauncher = "ulauncher"
+)
+
+// dmenuKeyPrefix marks the token id ending a dmenu line
+const dmenuKeyPrefix = "id:"
+
+// lineSafe keep s on one line of a launcher list
+func lineSafe(s string) string {
+	return strings.NewReplacer("\n", " ", "\r", " ", "\t", " ", "\x00", "", "\x1f", "").Replace(s)
+}
+
+// launcherLine title, code and remaining seconds of a result
+func launcherLine(o Output) string {
+	var line string
+	switch {
+	case o.Error != nil:
+		line = fmt.Sprintf("%s  %s", o.DecoratedTitle(), o.Error)
+	case o.ValidIn > 0:
+		line = fmt.Sprintf("%s  %s  (valid in %ds)", o.DecoratedTitle(), o.Code, o.ValidIn)
+	default:
+		line = fmt.Sprintf("%s  %s  (%ds)", o.DecoratedTitle(), o.Code, o.RemainSecs)
+	}
+
+	if o.Revoked {
+		line += revokedMark
+	}
+
+	return line
+}
+
+func outputKey(o Output) string {
+	if o.Token == nil {
+		return ""
+	}
+
+	return o.Token.Key()
+}
+
+// rofiRenderer rofi script mode rows, the token id is passed back in ROFI_INFO
+// and issuer, account and tags are matched as meta
+type rofiRenderer struct{}
+
+func (rofiRenderer) Render(w io.Writer, outputs []Output) error {
+	if _, err := io.WriteString(w, "\x00prompt\x1fauthy\n\x00no-custom\x1ftrue\n"); err != nil {
+		return err
+	}
+
+	for _, o := range outputs {
+		row := lineSafe(launcherLine(o))
+		if o.Token == nil || o.Error != nil {
+			row += "\x00nonselectable\x1ftrue"
+		} else {
+			row += "\x00info\x1f" + o.Token.Key() + "\x1fmeta\x1f" + lineSafe(o.Token.Details())
+		}
+
+		if _, err := io.WriteString(w, row+"\n"); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// dmenuRenderer one line per result ending with id: and the token id, so the
+// selected line can be passed to 'authy select'
+type dmenuRenderer struct{}
+
+func (dmenuRenderer) Render(w io.Writer, outputs []Output) error {
+	for _, o := range outputs {
+		line := lineSafe(launcherLine(o))
+		if key := outputKey(o); len(key) > 0 {
+			line += "  " + dmenuKeyPrefix + key
+		}
+
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// fzfRenderer tab separated, the token id first, to be hidden with
+// fzf --delimiter '\t' --with-nth 2..
+type fzfRenderer struct{}
+
+func (fzfRenderer) Render(w io.Writer, outputs []Output) error {
+	for _, o := range outputs {
+		details := ""
+		if o.Token != nil {
+			details = o.Token.Details()
+		}
+
+		_, err := fmt.Fprintf(w, "%s\t%s\t%s\n", outputKey(o), lineSafe(launcherLine(o)), lineSafe(details))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// UlauncherItem result item for a Ulauncher extension
+type UlauncherItem struct {
+	Name        string           `json:"name"`
+	Description string           `json:"description"`
+	Icon        string           `json:"icon,omitempty"`
+	Highlight   bool             `json:"highlightable"`
+	OnEnter     *UlauncherAction `json:"on_enter,omitempty"`
+	OnAltEnter  *UlauncherAction `json:"on_alt_enter,omitempty"`
+}
+
+// UlauncherAction what the extension does when the item is chosen
+type UlauncherAction struct {
+	// Type copy to put Data on the clipboard
+	Type string `json:"type"`
+	Data string `json:"data"`
+	// TokenID to run 'authy used' with
+	TokenID string `json:"token_id,omitempty"`
+}
+
+// ulauncherRenderer JSON array of items, Enter copies the code, Alt+Enter the next one
+type ulauncherRenderer struct{}
+
+func (ulauncherRenderer) Render(w io.Writer, outputs []Output) error {
+	items := make([]UlauncherItem, 0, len(outputs))
+	for _, o := range outputs {
+		item := UlauncherItem{
+			Name:        o.DecoratedTitle(),
+			Description: o.AfredSubtitle(),
+			Icon:        "images/icon.png",
+		}
+
+		if o.Error == nil {
+			item.OnEnter = &UlauncherAction{Type: "copy", Data: o.Code, TokenID: outputKey(o)}
+			if len(o.NextCode) > 0 {
+				item.OnAltEnter = &UlauncherAction{Type: "copy", Data: o.NextCode, TokenID: outputKey(o)}
+			}
+		}
+
+		items = append(items, item)
+	}
+
+	return json.NewEncoder(w).Encode(items)
+}
+
+// SelectedKey token id in a line printed by the dmenu or fzf formats, empty for
+// lines without one, like those of errors
+func SelectedKey(line string) string {
+	line = strings.TrimRight(line, "\r\n")
+	if i := strings.IndexByte(line, '\t'); i >= 0 {
+		// fzf, the id is the first field
+		return line[:i]
+	}
+
+	// dmenu, the id is the last field
+	fields := strings.Fields(line)
+	if len(fields) == 0 || !strings.HasPrefix(fields[len(fields)-1], dmenuKeyPrefix) {
+		return ""
+	}
+
+	return strings.TrimPrefix(fields[len(fields)-1], dmenuKeyPrefix)
+}
@@ -0,0 +1,77 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+)
+
+// ErrNotRegistered there is no saved device registration
+var ErrNotRegistered = errors.New("Device is not registered, run authy account")
+
+// DeviceStatus whether the saved device registration is still authorized
+type DeviceStatus struct {
+	UserID   uint64
+	DeviceID uint64
+	// Authorized the API accepted the device credentials
+	Authorized bool
+	// Message from Authy when the device is not authorized
+	Message string
+	// CachedTokens tokens in the local cache
+	CachedTokens int
+}
+
+// Status probe the Authy API with the saved device credentials, by the apps sync
+// request QueryAuthenticatorApps of github.com/alexzorin/authy sends during refresh
+func (d *Device) Status(ctx context.Context) (status DeviceStatus, err error) {
+	status = DeviceStatus{UserID: d.registration.UserID, DeviceID: d.registration.DeviceID}
+	if tks, err := d.readTokenCache(); err == nil {
+		status.CachedTokens = len(tks)
+	}
+
+	resp, err := d.deviceRequest(ctx, http.MethodPost,
+		fmt.Sprintf("users/%d/devices/%d/apps/sync", d.registration.UserID, d.registration.DeviceID),
+		url.Values{"locale": {"en-GB"}})
+	if err != nil {
+		return
+	}
+
+	switch {
+	case resp.Success:
+		status.Authorized = true
+	case unauthorized(resp):
+		status.Message = resp.Message
+	default:
+		return status, fmt.Errorf("Authy API error, HTTP %d: %s", resp.StatusCode, resp.Message)
+	}
+
+	d.setRevoked(!status.Authorized)
+	return
+}
+
+// unauthorized the API rejected the device credentials, other failures say nothing
+// about the device
+func unauthorized(resp apiResponse) bool {
+	return resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden
+}
+
+// Reregister register this computer as a new device, replacing the saved registration.
+// The token cache and the saved backup password are kept
+func (d *Device) Reregister(ctx context.Context) (DeviceRegistration, error) {
+	return d.newRegistrationDevice(ctx)
+}
+
+// Remove delete the saved registration, the token cache is kept. The device stays in
+// the Authy account, the API has no documented request to remove it, that is done
+// in the Authy app
+func (d *Device) Remove() error {
+	regrPath, err := d.ConfigPath(d.conf.ConfigFileName)
+	if err != nil {
+		return err
+	}
+
+	return os.Remove(regrPath)
+}
@@ -0,0 +1,174 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeAPI answers device requests by the last element of their path, e.g. "sync"
+type fakeAPI struct {
+	mu        sync.Mutex
+	responses map[string]fakeResponse
+	requests  []string
+}
+
+type fakeResponse struct {
+	status int
+	body   apiResponse
+}
+
+func (f *fakeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.requests = append(f.requests, r.URL.Path)
+
+	name := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+	resp, ok := f.responses[name]
+	if !ok {
+		resp = fakeResponse{status: http.StatusNotFound, body: apiResponse{Message: "not found"}}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(resp.status)
+	json.NewEncoder(w).Encode(resp.body)
+}
+
+func (f *fakeAPI) requested(name string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, p := range f.requests {
+		if strings.HasSuffix(p, "/"+name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+var (
+	apiOK      = fakeResponse{status: http.StatusOK, body: apiResponse{Success: true}}
+	apiDenied  = fakeResponse{status: http.StatusUnauthorized, body: apiResponse{Message: "Device was removed"}}
+	apiFailing = fakeResponse{status: http.StatusInternalServerError, body: apiResponse{Message: "Internal error"}}
+)
+
+// testDevice registered device in a temporary directory, talking to a fake API
+func testDevice(t *testing.T, responses map[string]fakeResponse) (*Device, *fakeAPI) {
+	t.Helper()
+
+	api := &fakeAPI{responses: responses}
+	srv := httptest.NewServer(api)
+	t.Cleanup(srv.Close)
+	t.Setenv(APIURLEnv, srv.URL+"/json")
+
+	d := newDevice(NewDeviceConfig{ConfigFilePath: t.TempDir()})
+	d.registration = DeviceRegistration{UserID: 1, DeviceID: 2, Seed: "0123456789abcdef0123456789abcdef"}
+	if err := d.SaveDeviceInfo(); err != nil {
+		t.Fatal(err)
+	}
+
+	return d, api
+}
+
+// reload the device from the saved registration
+func reload(t *testing.T, d *Device) *Device {
+	t.Helper()
+
+	loaded, err := LoadDevice(d.conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return loaded
+}
+
+func registrationExists(t *testing.T, d *Device) bool {
+	t.Helper()
+
+	path, err := d.ConfigPath(d.conf.ConfigFileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = os.Stat(path)
+	return err == nil
+}
+
+func TestStatusAuthorized(t *testing.T) {
+	d, api := testDevice(t, map[string]fakeResponse{"sync": apiOK})
+	d.setRevoked(true)
+
+	status, err := d.Status(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !status.Authorized || status.UserID != 1 || status.DeviceID != 2 {
+		t.Fatalf("got %+v, want device 2 of user 1 authorized", status)
+	}
+
+	if !api.requested("sync") {
+		t.Fatal("status didn't probe the API")
+	}
+
+	if reload(t, d).Revoked() {
+		t.Fatal("authorized device is still marked revoked")
+	}
+}
+
+func TestStatusRevoked(t *testing.T) {
+	d, _ := testDevice(t, map[string]fakeResponse{"sync": apiDenied})
+
+	status, err := d.Status(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if status.Authorized || status.Message != "Device was removed" {
+		t.Fatalf("got %+v, want not authorized with Authy's message", status)
+	}
+
+	if !reload(t, d).Revoked() {
+		t.Fatal("revoked device wasn't marked revoked")
+	}
+}
+
+func TestStatusServerErrorIsNotRevoked(t *testing.T) {
+	d, _ := testDevice(t, map[string]fakeResponse{"sync": apiFailing})
+
+	if _, err := d.Status(context.Background()); err == nil {
+		t.Fatal("server error was reported as a status")
+	}
+
+	if reload(t, d).Revoked() {
+		t.Fatal("server error marked the device revoked")
+	}
+}
+
+func TestRemove(t *testing.T) {
+	d, api := testDevice(t, nil)
+
+	if err := d.Remove(); err != nil {
+		t.Fatal(err)
+	}
+
+	if registrationExists(t, d) {
+		t.Fatal("registration wasn't deleted")
+	}
+
+	if len(api.requests) > 0 {
+		t.Fatalf("removing sent %v to Authy", api.requests)
+	}
+
+	if _, err := LoadDevice(d.conf); !errors.Is(err, ErrNotRegistered) {
+		t.Fatalf("got %v, want ErrNotRegistered", err)
+	}
+}
@@ -0,0 +1,17 @@
+//go:build !windows
+
+package service
+
+import (
+	"net"
+	"syscall"
+)
+
+// ListenUnix listen on a Unix socket only accessible by the current user, the umask
+// applies from its creation so nobody can connect before it is restricted
+func ListenUnix(path string) (net.Listener, error) {
+	old := syscall.Umask(0177)
+	defer syscall.Umask(old)
+
+	return net.Listen("unix", path)
+}
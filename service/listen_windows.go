@@ -0,0 +1,11 @@
+//go:build windows
+
+package service
+
+import "net"
+
+// ListenUnix listen on a Unix socket, Windows has no umask and file modes don't keep
+// other users out, access relies on the permissions of the directory holding it
+func ListenUnix(path string) (net.Listener, error) {
+	return net.Listen("unix", path)
+}
@@ -0,0 +1,124 @@
+package service
+
+import (
+	"strings"
+)
+
+// fillIssuerAccount derive issuer and account from "Issuer:account" style names if not set
+func (t *Token) fillIssuerAccount() {
+	if len(t.Issuer) > 0 {
+		return
+	}
+
+	name := t.OriginalName
+	if len(name) == 0 {
+		name = t.Name
+	}
+
+	if i := strings.Index(name, ":"); i > 0 {
+		t.Issuer = strings.TrimSpace(name[:i])
+		if len(t.Account) == 0 {
+			t.Account = strings.TrimSpace(name[i+1:])
+		}
+		return
+	}
+
+	t.Issuer = t.OriginalName
+}
+
+// HasTag case-insensitive
+func (t *Token) HasTag(tag string) bool {
+	for _, v := range t.Tags {
+		if strings.EqualFold(v, tag) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// AddTags add tags that are not present yet
+func (t *Token) AddTags(tags ...string) {
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if len(tag) == 0 || t.HasTag(tag) {
+			continue
+		}
+
+		t.Tags = append(t.Tags, tag)
+	}
+}
+
+// RemoveTags remove tags, case-insensitive
+func (t *Token) RemoveTags(tags ...string) {
+	kept := t.Tags[:0]
+	for _, v := range t.Tags {
+		remove := false
+		for _, tag := range tags {
+			if strings.EqualFold(v, strings.TrimSpace(tag)) {
+				remove = true
+				break
+			}
+		}
+
+		if !remove {
+			kept = append(kept, v)
+		}
+	}
+
+	t.Tags = kept
+	if len(t.Tags) == 0 {
+		t.Tags = nil
+	}
+}
+
+// Details issuer, account and tags in one line
+func (t Token) Details() string {
+	parts := []string{}
+	if len(t.Issuer) > 0 && t.Issuer != t.Title() {
+		parts = append(parts, t.Issuer)
+	}
+
+	if len(t.Account) > 0 && t.Account != t.Title() {
+		parts = append(parts, t.Account)
+	}
+
+	for _, tag := range t.Tags {
+		parts = append(parts, "#"+tag)
+	}
+
+	return strings.Join(parts, " · ")
+}
+
+// cachedCopies cached copy of each freshly fetched token, if any
+func cachedCopies(tks, cached []*Token) map[*Token]*Token {
+	old := make(map[string]*Token, len(cached))
+	for _, tk := range cached {
+		old[tk.Key()] = tk
+		// caches written before tokens had an id
+		old[generateMD5(tk)] = tk
+	}
+
+	copies := make(map[*Token]*Token, len(tks))
+	for _, tk := range tks {
+		o, ok := old[tk.Key()]
+		if !ok {
+			o, ok = old[generateMD5(tk)]
+		}
+		if ok {
+			copies[tk] = o
+		}
+	}
+
+	return copies
+}
+
+// keepLocalMetadata copy locally edited fields of cached tokens to freshly fetched ones
+func keepLocalMetadata(tks, cached []*Token) {
+	for tk, o := range cachedCopies(tks, cached) {
+		tk.Alias = o.Alias
+		tk.Tags = o.Tags
+		tk.Notes = o.Notes
+		tk.Favorite = o.Favorite
+	}
+}
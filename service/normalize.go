@@ -0,0 +1,157 @@
+package service
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/mozillazg/go-pinyin"
+	"golang.org/x/text/unicode/norm"
+)
+
+// searchForm searchable spelling of a string, e.g. accent-stripped or pinyin
+type searchForm struct {
+	text string
+	// origin rune index in the original string of every byte in text
+	origin []int
+}
+
+func (f *searchForm) add(s string, ri int) {
+	f.text += s
+	for i := 0; i < len(s); i++ {
+		f.origin = append(f.origin, ri)
+	}
+}
+
+// runes original rune indexes covered by text[start:end]
+func (f searchForm) runes(start, end int) []int {
+	ret := []int{}
+	for i := start; i < end && i < len(f.origin); i++ {
+		if len(ret) == 0 || ret[len(ret)-1] != f.origin[i] {
+			ret = append(ret, f.origin[i])
+		}
+	}
+
+	return ret
+}
+
+var pinyinArgs = pinyin.Args{Style: pinyin.Normal, Heteronym: true}
+
+// maxPinyinVariants limit of spellings tried for names with polyphonic characters
+const maxPinyinVariants = 8
+
+// searchForms the string itself, then without diacritics, full pinyin and pinyin initials
+// when they are different
+func searchForms(s string) []searchForm {
+	var (
+		stripped searchForm
+		readings = [][]string{}
+		hasHan   bool
+	)
+
+	for ri, r := range []rune(s) {
+		plain := stripDiacritics(r)
+		stripped.add(plain, ri)
+
+		pys := []string{plain}
+		if unicode.Is(unicode.Han, r) {
+			if alt := uniqueStrings(pinyin.SinglePinyin(r, pinyinArgs)); len(alt) > 0 {
+				pys = alt
+				hasHan = true
+			}
+		}
+
+		readings = append(readings, pys)
+	}
+
+	forms := []searchForm{{text: s, origin: byteOrigins(s)}}
+	if stripped.text != s {
+		forms = append(forms, stripped)
+	}
+
+	if hasHan {
+		for _, variant := range pinyinVariants(readings) {
+			var full, initials searchForm
+			for ri, py := range variant {
+				full.add(py, ri)
+				initials.add(string([]rune(py)[:1]), ri)
+			}
+
+			forms = append(forms, full, initials)
+		}
+	}
+
+	return forms
+}
+
+// pinyinVariants combinations of readings of polyphonic characters, the most common reading first
+func pinyinVariants(readings [][]string) [][]string {
+	variants := [][]string{{}}
+	for _, pys := range readings {
+		next := [][]string{}
+		for _, v := range variants {
+			for _, py := range pys {
+				if len(next) >= maxPinyinVariants {
+					break
+				}
+
+				next = append(next, append(append([]string{}, v...), py))
+			}
+		}
+
+		variants = next
+	}
+
+	return variants
+}
+
+func uniqueStrings(ss []string) []string {
+	ret := []string{}
+	seen := map[string]bool{}
+	for _, s := range ss {
+		if len(s) > 0 && !seen[s] {
+			seen[s] = true
+			ret = append(ret, s)
+		}
+	}
+
+	return ret
+}
+
+func byteOrigins(s string) []int {
+	origin := make([]int, 0, len(s))
+	ri := 0
+	for _, r := range s {
+		for i := 0; i < utf8.RuneLen(r); i++ {
+			origin = append(origin, ri)
+		}
+		ri++
+	}
+
+	return origin
+}
+
+// stripDiacritics é -> e, ß stays ß
+func stripDiacritics(r rune) string {
+	var b strings.Builder
+	for _, c := range norm.NFD.String(string(r)) {
+		if !unicode.Is(unicode.Mn, c) {
+			b.WriteRune(c)
+		}
+	}
+
+	if b.Len() == 0 {
+		return string(r)
+	}
+
+	return b.String()
+}
+
+func stripAllDiacritics(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		b.WriteString(stripDiacritics(r))
+	}
+
+	return b.String()
+}
@@ -3,40 +3,100 @@ package service
 import (
 	"encoding/json"
 	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
 )
 
-// AlfredOutput ..
+// AlfredOutput item of an Alfred Script Filter
 type AlfredOutput struct {
-	Title    string `json:"title"`
-	Subtitle string `json:"subtitle"`
-	Arg      string `json:"arg"`
-	Icon     struct {
-		Type string `json:"type"`
-		Path string `json:"path"`
-	} `json:"icon"`
-	Valid bool `json:"valid"`
-	Text  struct {
-		Copy string `json:"copy"`
+	UID      string      `json:"uid,omitempty"`
+	Title    string      `json:"title"`
+	Subtitle string      `json:"subtitle"`
+	Arg      string      `json:"arg"`
+	Icon     *AlfredIcon `json:"icon,omitempty"`
+	Valid    bool        `json:"valid"`
+	Text     struct {
+		Copy      string `json:"copy,omitempty"`
+		Largetype string `json:"largetype,omitempty"`
 	} `json:"text"`
+	Mods      map[string]AlfredMod `json:"mods,omitempty"`
+	Variables map[string]string    `json:"variables,omitempty"`
 }
 
+// AlfredIcon icon of an item, Path relative to the workflow folder
+type AlfredIcon struct {
+	Type string `json:"type,omitempty"`
+	Path string `json:"path"`
+}
+
+// AlfredMod replaces arg and subtitle while a modifier key is held
+type AlfredMod struct {
+	Valid     bool              `json:"valid"`
+	Arg       string            `json:"arg"`
+	Subtitle  string            `json:"subtitle"`
+	Variables map[string]string `json:"variables,omitempty"`
+}
+
+// AlfredResult Script Filter output
+type AlfredResult struct {
+	// Rerun seconds after which Alfred runs the script again while it is open
+	Rerun float64        `json:"rerun,omitempty"`
+	Items []AlfredOutput `json:"items"`
+}
+
+const (
+	// alfredRerun keeps countdowns accurate while Alfred is open
+	alfredRerun = 1
+	// alfredIconDir per issuer icons in the workflow folder, e.g. icons/github.png
+	alfredIconDir = "icons"
+	// alfredErrorIcon macOS system alert icon
+	alfredErrorIcon = "/System/Library/CoreServices/CoreTypes.bundle/Contents/Resources/AlertStopIcon.icns"
+)
+
 // Output cale token output
 type Output struct {
 	*Token
 	OTitle     string
 	Code       string
 	RemainSecs int
+	Period     int
+	// NextCode code of the following period
+	NextCode string
+	// ValidIn seconds until Code becomes valid, set when it is the next period's code
+	ValidIn int
+	// Highlights rune indexes of Title matched by the query
+	Highlights []int
+	// Revoked the code comes from the cache of a revoked device
+	Revoked bool
 
 	Error error
 }
 
+// nearExpirySecs show the upcoming code when the current one expires this soon
+const nearExpirySecs = 5
+
 // AfredSubtitle alfred subtitle
 func (o Output) AfredSubtitle() string {
 	if o.Error != nil {
 		return o.Error.Error()
 	}
 
-	return fmt.Sprintf("Code: %s [Press Enter copy to clipboard], Expires in %d second(s)", o.Code, o.RemainSecs)
+	subtitle := fmt.Sprintf("Code: %s [Press Enter copy to clipboard], Expires in %d second(s)", o.Code, o.RemainSecs)
+	if o.ValidIn > 0 {
+		subtitle = fmt.Sprintf("Code: %s [Press Enter copy to clipboard], Valid in %d second(s) for %d second(s)", o.Code, o.ValidIn, o.Period)
+	} else if o.RemainSecs <= nearExpirySecs && len(o.NextCode) > 0 {
+		subtitle += fmt.Sprintf(", Next: %s", o.NextCode)
+	}
+
+	if o.Token != nil && len(o.Token.Details()) > 0 {
+		subtitle = o.Token.Details() + " | " + subtitle
+	}
+
+	return subtitle
 }
 
 // Title ...
@@ -48,34 +108,233 @@ func (o Output) Title() string {
 	return o.OTitle
 }
 
+// DecoratedTitle title with favorite mark
+func (o Output) DecoratedTitle() string {
+	return o.HighlightedTitle(func(r rune) string { return string(r) })
+}
+
+// HighlightedTitle title with favorite mark, matched runes rendered by mark
+func (o Output) HighlightedTitle(mark func(r rune) string) string {
+	var (
+		b          strings.Builder
+		highlights = map[int]bool{}
+	)
+
+	if o.Token != nil && o.Token.Favorite {
+		b.WriteString("★ ")
+	}
+
+	for _, i := range o.Highlights {
+		highlights[i] = true
+	}
+
+	for i, r := range []rune(o.Title()) {
+		if highlights[i] {
+			b.WriteString(mark(r))
+		} else {
+			b.WriteRune(r)
+		}
+	}
+
+	return b.String()
+}
+
+// alfredMark underline with combining low line, Alfred titles are plain text
+func alfredMark(r rune) string {
+	return string(r) + "\u0332"
+}
+
+// prettyMark underline inside the colored title
+func prettyMark(r rune) string {
+	return "\033[4m" + string(r) + "\033[24m"
+}
+
 // ToAfred  to alfred output
 func (o Output) ToAfred() AlfredOutput {
-	return AlfredOutput{
-		Title:    o.Title(),
+	out := AlfredOutput{
+		Title:    o.HighlightedTitle(alfredMark),
 		Subtitle: o.AfredSubtitle(),
 		Arg:      o.Code,
-		Valid:    true,
+		Valid:    o.Error == nil,
 	}
+
+	if o.Error != nil {
+		out.Icon = &AlfredIcon{Path: alfredErrorIcon}
+		return out
+	}
+
+	out.Text.Copy = o.Code
+	out.Text.Largetype = o.Code
+	if o.Token == nil {
+		return out
+	}
+
+	// Alfred learns the order from stable uids
+	out.UID = o.Token.Key()
+	out.Icon = alfredIcon(o.Token)
+	if len(o.Token.Notes) > 0 {
+		out.Text.Largetype += "\n\n" + o.Token.Notes
+	}
+
+	// lets the workflow run 'authy used $token_id' after copying
+	out.Variables = map[string]string{
+		"token_id": o.Token.Key(),
+		"title":    o.Title(),
+	}
+
+	out.Mods = map[string]AlfredMod{}
+	if len(o.NextCode) > 0 {
+		out.Mods["alt"] = AlfredMod{
+			Valid:     true,
+			Arg:       o.NextCode,
+			Subtitle:  fmt.Sprintf("Copy next code %s, valid in %d second(s)", o.NextCode, o.RemainSecs),
+			Variables: out.Variables,
+		}
+	}
+
+	if uri := o.Token.OTPAuthURI(); len(uri) > 0 {
+		out.Mods["cmd"] = AlfredMod{
+			Valid:     true,
+			Arg:       uri,
+			Subtitle:  "Show otpauth URI",
+			Variables: out.Variables,
+		}
+	}
+
+	return out
 }
 
-func (s *Searcher) showResult(outputs []Output) {
-	if s.isAlfred {
-		s.showAlfredOutput(outputs)
-		return
+// alfredIcon icons/<issuer>.png of the workflow folder if it exists, otherwise the workflow icon
+func alfredIcon(tk *Token) *AlfredIcon {
+	for _, name := range []string{tk.Issuer, tk.Name} {
+		slug := iconSlug(name)
+		if len(slug) == 0 {
+			continue
+		}
+
+		path := filepath.Join(alfredIconDir, slug+".png")
+		if _, err := os.Stat(path); err == nil {
+			return &AlfredIcon{Path: path}
+		}
+	}
+
+	return nil
+}
+
+// iconSlug lower case letters and digits of name, "Google Cloud" becomes "googlecloud"
+func iconSlug(name string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(name) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+		}
+	}
+
+	return b.String()
+}
+
+// Renderer writes search results in one output format
+type Renderer interface {
+	Render(w io.Writer, outputs []Output) error
+}
+
+// Output formats
+const (
+	FormatPretty   = "pretty"
+	FormatAlfred   = "alfred"
+	FormatJSON     = "json"
+	FormatJSONL    = "jsonl"
+	FormatCSV      = "csv"
+	FormatTSV      = "tsv"
+	FormatPlain    = "plain"
+	FormatTemplate = "template"
+)
+
+// Formats all supported output formats
+var Formats = []string{
+	FormatPretty, FormatAlfred, FormatJSON, FormatJSONL, FormatCSV, FormatTSV, FormatPlain, FormatTemplate,
+	FormatRofi, FormatDmenu, FormatFzf, FormatUlauncher,
+	FormatWaybar, FormatI3blocks, FormatPolybar, FormatTmux, FormatRaycast,
+}
+
+// CanWait results in format are read by a person at the terminal, who can wait for a
+// fresh code. Launchers, status bars and scripts poll the other formats and must get
+// an answer at once
+func CanWait(format string) bool {
+	switch strings.ToLower(format) {
+	case "", FormatPretty:
+		return true
+	}
+
+	return false
+}
+
+// NewRenderer renderer of format, tmpl is a text/template executed for every result of FormatTemplate
+func NewRenderer(format, tmpl string) (Renderer, error) {
+	switch strings.ToLower(format) {
+	case "", FormatPretty:
+		return prettyRenderer{}, nil
+	case FormatAlfred:
+		return alfredRenderer{}, nil
+	case FormatJSON:
+		return jsonRenderer{}, nil
+	case FormatJSONL:
+		return jsonlRenderer{}, nil
+	case FormatCSV:
+		return delimitedRenderer{comma: ','}, nil
+	case FormatTSV:
+		return delimitedRenderer{comma: '\t'}, nil
+	case FormatPlain:
+		return plainRenderer{}, nil
+	case FormatTemplate:
+		return newTemplateRenderer(tmpl)
+	case FormatRofi:
+		return rofiRenderer{}, nil
+	case FormatDmenu:
+		return dmenuRenderer{}, nil
+	case FormatFzf:
+		return fzfRenderer{}, nil
+	case FormatUlauncher:
+		return ulauncherRenderer{}, nil
+	case FormatWaybar:
+		return waybarRenderer{}, nil
+	case FormatI3blocks:
+		return i3blocksRenderer{}, nil
+	case FormatPolybar:
+		return polybarRenderer{}, nil
+	case FormatTmux:
+		return tmuxRenderer{}, nil
+	case FormatRaycast:
+		return raycastRenderer{}, nil
 	}
 
-	s.prettyPrintResult(outputs)
+	return nil, fmt.Errorf("Invalid output format %q, must be one of %v", format, Formats)
 }
 
-func (s *Searcher) showAlfredOutput(out []Output) {
-	alfredOut := make([]AlfredOutput, 0, len(out))
+func (s *Searcher) showResult(outputs []Output) {
+	if err := s.renderer.Render(os.Stdout, outputs); err != nil {
+		log.Println("Render output failed", err)
+	}
+}
+
+type alfredRenderer struct{}
+
+func (alfredRenderer) Render(w io.Writer, out []Output) error {
+	result := AlfredResult{Items: make([]AlfredOutput, 0, len(out))}
 	for _, v := range out {
-		alfredOut = append(alfredOut, v.ToAfred())
+		result.Items = append(result.Items, v.ToAfred())
+		if v.Error == nil {
+			result.Rerun = alfredRerun
+		}
+	}
+
+	b, err := json.Marshal(result)
+	if err != nil {
+		return err
 	}
 
-	m := map[string][]AlfredOutput{"items": alfredOut}
-	b, _ := json.Marshal(m)
-	fmt.Println(string(b))
+	_, err = fmt.Fprintln(w, string(b))
+	return err
 }
 
 const (
@@ -87,16 +346,28 @@ const (
 	Teal = "\033[1;36m%s\033[0m"
 )
 
-func (s *Searcher) prettyPrintResult(outputs []Output) {
-	fmt.Printf("\n")
+type prettyRenderer struct{}
+
+func (prettyRenderer) Render(w io.Writer, outputs []Output) error {
+	fmt.Fprintf(w, "\n")
 	for _, tk := range outputs {
-		fmt.Printf("- Title: "+Green+"\n", tk.Title())
+		fmt.Fprintf(w, "- Title: "+Green+"\n", tk.HighlightedTitle(prettyMark))
+		if tk.Token != nil {
+			if details := tk.Token.Details(); len(details) > 0 {
+				fmt.Fprintf(w, "- %s\n", details)
+			}
+			if len(tk.Token.Notes) > 0 {
+				fmt.Fprintf(w, "- Notes: %s\n", tk.Token.Notes)
+			}
+		}
 		if tk.Error != nil {
-			fmt.Printf("- %v\n\n", tk.Error)
+			fmt.Fprintf(w, "- %v\n\n", tk.Error)
+		} else if tk.ValidIn > 0 {
+			fmt.Fprintf(w, "- Code: "+Teal+" Valid in "+Red+"(s), expires in %d(s)\n\n", tk.Code, fmt.Sprint(tk.ValidIn), tk.RemainSecs)
 		} else {
-			fmt.Printf("- Code: "+Teal+" Expires in "+Red+"(s)\n\n", tk.Code, fmt.Sprint(tk.RemainSecs))
+			fmt.Fprintf(w, "- Code: "+Teal+" Expires in "+Red+"(s)\n\n", tk.Code, fmt.Sprint(tk.RemainSecs))
 		}
 	}
 
-	return
+	return nil
 }
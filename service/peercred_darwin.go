@@ -0,0 +1,46 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// peerCredentials checkPeer compares the peer uid
+const peerCredentials = true
+
+// checkPeer only serve processes of the same user
+func checkPeer(conn net.Conn) error {
+	uc, ok := conn.(*net.UnixConn)
+	if !ok {
+		return errors.New("not a unix socket connection")
+	}
+
+	raw, err := uc.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var (
+		cred    *unix.Xucred
+		credErr error
+	)
+	err = raw.Control(func(fd uintptr) {
+		cred, credErr = unix.GetsockoptXucred(int(fd), unix.SOL_LOCAL, unix.LOCAL_PEERCRED)
+	})
+	if err != nil {
+		return err
+	}
+	if credErr != nil {
+		return credErr
+	}
+
+	if int(cred.Uid) != os.Getuid() {
+		return fmt.Errorf("peer uid %d is not %d", cred.Uid, os.Getuid())
+	}
+
+	return nil
+}
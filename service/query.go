@@ -0,0 +1,286 @@
+package service
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/sahilm/fuzzy"
+)
+
+type matchKind int
+
+const (
+	matchFuzzy matchKind = iota
+	matchExact
+	matchRegex
+)
+
+// query fields, a term without field matches any of them
+const (
+	fieldName    = "name"
+	fieldIssuer  = "issuer"
+	fieldAccount = "account"
+	fieldTag     = "tag"
+)
+
+var queryFields = []string{fieldName, fieldIssuer, fieldAccount, fieldTag}
+
+type queryTerm struct {
+	field  string
+	value  string
+	negate bool
+	kind   matchKind
+	re     *regexp.Regexp
+}
+
+// Query parsed search query, a token matches when all terms match
+//
+// Syntax, terms separated by spaces:
+//
+//	word          fuzzy match against name, issuer, account and tags, also
+//	              ignoring accents and by pinyin or pinyin initials
+//	"some words"  case-insensitive substring match
+//	/regexp/      regular expression match, case-insensitive
+//	-term         token must not match term
+//	name:term     only match the given field: name, issuer, account or tag
+//
+// A plain word after tag: must equal one of the tags.
+type Query struct {
+	terms []queryTerm
+}
+
+// ParseQuery parse search query
+func ParseQuery(s string) (q Query, err error) {
+	rs := []rune(s)
+	for i := 0; i < len(rs); {
+		if unicode.IsSpace(rs[i]) {
+			i++
+			continue
+		}
+
+		var t queryTerm
+		t, i, err = parseTerm(rs, i)
+		if err != nil {
+			return
+		}
+
+		if len(t.value) > 0 || t.kind != matchFuzzy {
+			q.terms = append(q.terms, t)
+		}
+	}
+
+	return
+}
+
+func parseTerm(rs []rune, i int) (t queryTerm, next int, err error) {
+	if rs[i] == '-' && i+1 < len(rs) && !unicode.IsSpace(rs[i+1]) {
+		t.negate = true
+		i++
+	}
+
+	for _, f := range queryFields {
+		prefix := []rune(f + ":")
+		if hasRunePrefixFold(rs[i:], prefix) {
+			t.field = f
+			i += len(prefix)
+			break
+		}
+	}
+
+	if i >= len(rs) {
+		return t, i, nil
+	}
+
+	switch rs[i] {
+	case '"':
+		end := indexRune(rs, i+1, '"')
+		if end < 0 {
+			return t, i, fmt.Errorf("Unterminated quote in query: %s", string(rs[i:]))
+		}
+
+		t.kind = matchExact
+		t.value = strings.ToLower(stripAllDiacritics(strings.ReplaceAll(string(rs[i+1:end]), `\"`, `"`)))
+		return t, end + 1, nil
+	case '/':
+		end := indexRune(rs, i+1, '/')
+		if end < 0 {
+			return t, i, fmt.Errorf("Unterminated regexp in query: %s", string(rs[i:]))
+		}
+
+		t.kind = matchRegex
+		t.value = string(rs[i+1 : end])
+		t.re, err = regexp.Compile("(?i)" + t.value)
+		if err != nil {
+			return t, i, fmt.Errorf("Invalid regexp in query /%s/: %v", t.value, err)
+		}
+		return t, end + 1, nil
+	}
+
+	start := i
+	for i < len(rs) && !unicode.IsSpace(rs[i]) {
+		i++
+	}
+
+	t.value = stripAllDiacritics(string(rs[start:i]))
+	return t, i, nil
+}
+
+func hasRunePrefixFold(rs, prefix []rune) bool {
+	if len(rs) < len(prefix) {
+		return false
+	}
+
+	return strings.EqualFold(string(rs[:len(prefix)]), string(prefix))
+}
+
+// indexRune index of r from start, a backslash escapes r
+func indexRune(rs []rune, start int, r rune) int {
+	for i := start; i < len(rs); i++ {
+		if rs[i] == '\\' {
+			i++
+			continue
+		}
+
+		if rs[i] == r {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// IsEmpty query without terms matches everything
+func (q Query) IsEmpty() bool {
+	return len(q.terms) == 0
+}
+
+// Match whether token matches all terms, score is higher for better fuzzy matches
+func (q Query) Match(tk *Token) (score int, ok bool) {
+	for _, t := range q.terms {
+		s, matched := t.match(tk)
+		if matched == t.negate {
+			return 0, false
+		}
+
+		if !t.negate {
+			score += s
+		}
+	}
+
+	return score, true
+}
+
+func (t queryTerm) match(tk *Token) (score int, ok bool) {
+	if t.kind == matchFuzzy && t.field == fieldTag {
+		for _, tag := range tk.Tags {
+			if strings.EqualFold(stripAllDiacritics(tag), t.value) {
+				return 0, true
+			}
+		}
+		return 0, false
+	}
+
+	for _, v := range tokenFieldValues(tk, t.field) {
+		// match each field on its own so a term never spans two fields
+		if s, _, matched := t.locate(v); matched && (!ok || s > score) {
+			score, ok = s, true
+		}
+	}
+
+	return
+}
+
+// locate best match of term in value or one of its search forms, runes are the
+// matched rune indexes in value
+func (t queryTerm) locate(value string) (score int, runes []int, ok bool) {
+	for _, form := range searchForms(value) {
+		switch t.kind {
+		case matchExact:
+			lower := strings.ToLower(form.text)
+			if i := strings.Index(lower, t.value); i >= 0 {
+				if len(lower) == len(form.text) {
+					runes = form.runes(i, i+len(t.value))
+				}
+				return 0, runes, true
+			}
+		case matchRegex:
+			if loc := t.re.FindStringIndex(form.text); loc != nil {
+				return 0, form.runes(loc[0], loc[1]), true
+			}
+		default:
+			for _, m := range fuzzy.Find(t.value, []string{form.text}) {
+				if ok && m.Score <= score {
+					continue
+				}
+
+				score, ok, runes = m.Score, true, nil
+				for _, i := range m.MatchedIndexes {
+					runes = append(runes, form.origin[i])
+				}
+			}
+		}
+	}
+
+	return
+}
+
+// Highlight rune indexes of the token title matched by the query
+func (q Query) Highlight(tk *Token) []int {
+	var (
+		title = tk.Title()
+		seen  = map[int]bool{}
+		ret   = []int{}
+	)
+
+	for _, t := range q.terms {
+		if t.negate || (t.field != "" && t.field != fieldName) {
+			continue
+		}
+
+		_, runes, ok := t.locate(title)
+		if !ok {
+			continue
+		}
+
+		for _, r := range runes {
+			if !seen[r] {
+				seen[r] = true
+				ret = append(ret, r)
+			}
+		}
+	}
+
+	sort.Ints(ret)
+	return ret
+}
+
+func tokenFieldValues(tk *Token, field string) []string {
+	var values []string
+	if field == "" || field == fieldName {
+		values = append(values, tk.Title(), tk.Name, tk.OriginalName)
+	}
+
+	if field == "" || field == fieldIssuer {
+		values = append(values, tk.Issuer)
+	}
+
+	if field == "" || field == fieldAccount {
+		values = append(values, tk.Account)
+	}
+
+	if field == "" || field == fieldTag {
+		values = append(values, tk.Tags...)
+	}
+
+	nonEmpty := values[:0]
+	for _, v := range values {
+		if len(v) > 0 {
+			nonEmpty = append(nonEmpty, v)
+		}
+	}
+
+	return nonEmpty
+}
@@ -0,0 +1,259 @@
+package service
+
+import (
+	"context"
+	"crypto/aes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/alexzorin/authy"
+)
+
+// Reasons a token failed to refresh
+const (
+	RefreshWrongPassword      = "wrong password"
+	RefreshUnsupportedVersion = "unsupported encryption version"
+	RefreshMalformedSecret    = "malformed secret"
+)
+
+// fetchTimeout limit of the requests fetching the tokens, including the device check
+// when they are rejected
+const fetchTimeout = 30 * time.Second
+
+// RefreshResult outcome of a refresh from the Authy server
+type RefreshResult struct {
+	// Saved tokens in the cache, including kept copies of failed ones
+	Saved int
+	// Refreshed tokens decrypted or read from the server
+	Refreshed int
+	Failures  []RefreshFailure
+}
+
+// RefreshFailure token that couldn't be refreshed
+type RefreshFailure struct {
+	ID     string
+	Name   string
+	Reason string
+	Err    error
+	// Kept the previous cached copy is still in the cache
+	Kept bool
+}
+
+// WriteReport summary line, and a table of the failed tokens if any
+func (r RefreshResult) WriteReport(w io.Writer) error {
+	if len(r.Failures) == 0 {
+		_, err := fmt.Fprintf(w, "Refreshed %d token(s)\n", r.Refreshed)
+		return err
+	}
+
+	fmt.Fprintf(w, "Refreshed %d token(s), %d failed:\n\n", r.Refreshed, len(r.Failures))
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TOKEN\tREASON\tCACHE\tERROR")
+	for _, f := range r.Failures {
+		cache := "dropped"
+		if f.Kept {
+			cache = "kept previous copy"
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%v\n", lineSafe(f.Name), f.Reason, cache, f.Err)
+	}
+
+	return tw.Flush()
+}
+
+// Refresh fetch and decrypt all tokens from the Authy server and save them, make sure
+// that you've enabled Authenticator Backups And Multi-Device Sync. Tokens failing to
+// decrypt are reported, their previous cached copies are kept
+func (d *Device) Refresh(ctx context.Context) (result RefreshResult, err error) {
+	fetched, result, err := d.fetchTokens(ctx)
+	if err != nil {
+		return
+	}
+
+	cached, cacheErr := d.readTokenCache()
+	if fetched.noneDecrypted() && len(cached) > 0 {
+		return result, fmt.Errorf("None of the %d tokens could be decrypted, keeping the existing token cache", fetched.encrypted)
+	}
+
+	tks := fetched.tokens
+	if cacheErr == nil {
+		keepLocalMetadata(tks, cached)
+		d.migrateUsageKeys(tks, cached)
+		tks = keepFailedTokens(tks, cached, result.Failures)
+	}
+
+	result.Saved = len(tks)
+
+	d.tokenMap = tokensToMap(tks)
+	d.tokens = tks
+	d.saveToken()
+	d.setRevoked(false)
+	return
+}
+
+// DecryptTokens fetch and decrypt all tokens from the Authy server into memory only,
+// nothing is written to the token cache. Metadata edited locally is still applied
+func (d *Device) DecryptTokens(ctx context.Context) ([]*Token, RefreshResult, error) {
+	fetched, result, err := d.fetchTokens(ctx)
+	if err != nil {
+		return nil, result, err
+	}
+
+	if fetched.noneDecrypted() {
+		return nil, result, fmt.Errorf("None of the %d tokens could be decrypted", fetched.encrypted)
+	}
+
+	if cached, err := d.readTokenCache(); err == nil {
+		keepLocalMetadata(fetched.tokens, cached)
+	}
+
+	return fetched.tokens, result, nil
+}
+
+// fetchedTokens tokens decrypted by fetchTokens
+type fetchedTokens struct {
+	tokens []*Token
+	// encrypted authenticator tokens on the server, decrypted how many of them could be
+	encrypted, decrypted int
+}
+
+func (f fetchedTokens) noneDecrypted() bool {
+	return f.encrypted > 0 && f.decrypted == 0
+}
+
+// fetchTokens fetch and decrypt the authenticator tokens and apps from the Authy server
+func (d *Device) fetchTokens(ctx context.Context) (fetched fetchedTokens, result RefreshResult, err error) {
+	client, err := authy.NewClient()
+	if err != nil {
+		return fetched, result, fmt.Errorf("Create authy API client failed %+v", err)
+	}
+
+	// the requests are done before the password may be asked for
+	reqCtx, cancel := context.WithTimeout(ctx, fetchTimeout)
+	defer cancel()
+
+	apps, err := client.QueryAuthenticatorApps(reqCtx, d.registration.UserID, d.registration.DeviceID, d.registration.Seed)
+	if err != nil {
+		return fetched, result, fmt.Errorf("Fetch authenticator apps failed %+v", err)
+	}
+
+	if !apps.Success {
+		return fetched, result, d.fetchError(reqCtx, "Fetch authenticator apps failed", apps)
+	}
+
+	tokens, err := d.queryAuthenticatorTokens(reqCtx, client)
+	if err != nil {
+		return
+	}
+
+	mainpwd, ref, err := d.getMainPassword(tokens.AuthenticatorTokens)
+	if err != nil {
+		return
+	}
+
+	fetched.tokens = []*Token{}
+	fetched.encrypted = len(tokens.AuthenticatorTokens)
+	for _, v := range tokens.AuthenticatorTokens {
+		secret, reason, err := decryptAuthenticatorToken(v, mainpwd, ref)
+		if err != nil {
+			result.Failures = append(result.Failures, RefreshFailure{ID: v.UniqueID, Name: v.Description(), Reason: reason, Err: err})
+			continue
+		}
+
+		tk := &Token{
+			ID:           v.UniqueID,
+			Name:         v.Name,
+			OriginalName: v.OriginalName,
+			Digital:      v.Digits,
+			Secret:       secret,
+		}
+		tk.fillIssuerAccount()
+		if len(tk.Issuer) == 0 && v.AccountType != "authenticator" {
+			tk.Issuer = v.AccountType
+		}
+
+		fetched.tokens = append(fetched.tokens, tk)
+	}
+	fetched.decrypted = len(fetched.tokens)
+
+	for _, v := range apps.AuthenticatorApps {
+		secret, err := v.Token()
+		if err != nil {
+			result.Failures = append(result.Failures, RefreshFailure{ID: v.ID, Name: v.Name, Reason: RefreshMalformedSecret, Err: err})
+			continue
+		}
+
+		fetched.tokens = append(fetched.tokens, &Token{
+			ID:      v.ID,
+			Name:    v.Name,
+			Digital: v.Digits,
+			Secret:  secret,
+			Period:  10,
+			Issuer:  v.Name,
+		})
+	}
+
+	result.Refreshed = len(fetched.tokens)
+	return
+}
+
+// keepFailedTokens append the cached copies of the failed tokens to tks
+func keepFailedTokens(tks, cached []*Token, failures []RefreshFailure) []*Token {
+	old := make(map[string]*Token, len(cached))
+	for _, tk := range cached {
+		if len(tk.ID) > 0 {
+			old[tk.ID] = tk
+		}
+	}
+
+	for i, f := range failures {
+		if tk, ok := old[f.ID]; ok && len(f.ID) > 0 {
+			tks = append(tks, tk)
+			failures[i].Kept = true
+		}
+	}
+
+	return tks
+}
+
+// checkSeed the encrypted seed can be fed to Decrypt, which panics on partial blocks
+func checkSeed(v authy.AuthenticatorToken) error {
+	seed, err := base64.StdEncoding.DecodeString(v.EncryptedSeed)
+	if err != nil {
+		return fmt.Errorf("Encrypted seed isn't base64: %v", err)
+	}
+
+	if len(seed) == 0 || len(seed)%aes.BlockSize != 0 {
+		return fmt.Errorf("Encrypted seed is %d bytes, not a multiple of %d", len(seed), aes.BlockSize)
+	}
+
+	return nil
+}
+
+// decryptAuthenticatorToken secret of v, or why it can't be decrypted. ref is the token
+// the password decrypted when checked, tokens encrypted with the same password but
+// still failing use an encryption this version doesn't support
+func decryptAuthenticatorToken(v authy.AuthenticatorToken, pwd string, ref authy.AuthenticatorToken) (secret, reason string, err error) {
+	if err = checkSeed(v); err != nil {
+		return "", RefreshMalformedSecret, err
+	}
+
+	secret, err = v.Decrypt(pwd)
+	if err != nil {
+		if v.PasswordTimestamp != ref.PasswordTimestamp {
+			return "", RefreshWrongPassword, fmt.Errorf("%v, it was encrypted with another backup password", err)
+		}
+
+		return "", RefreshUnsupportedVersion, err
+	}
+
+	if !isBase32(secret) {
+		return "", RefreshMalformedSecret, fmt.Errorf("Decrypted secret isn't base32")
+	}
+
+	return secret, "", nil
+}
@@ -0,0 +1,214 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/alexzorin/authy"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// RegisterViaEnv registration method used when NewDeviceConfig.Via is empty
+const RegisterViaEnv = "AUTHY_REGISTER_VIA"
+
+const (
+	defaultRegisterTimeout = 5 * time.Minute
+	defaultPollInterval    = 5 * time.Second
+	maxPollInterval        = 30 * time.Second
+)
+
+// registrationMethod push (default), sms or call
+func registrationMethod(via string) (authy.ViaMethod, error) {
+	switch m := authy.ViaMethod(strings.ToLower(via)); m {
+	case "":
+		return authy.ViaMethodPush, nil
+	case authy.ViaMethodPush, authy.ViaMethodSMS, authy.ViaMethodCall:
+		return m, nil
+	}
+
+	return "", fmt.Errorf("Unknown registration method %q, use push, sms or call", via)
+}
+
+// checkRegistrationMethod fail before anything is sent when the PIN couldn't be entered
+func checkRegistrationMethod(via string) (authy.ViaMethod, error) {
+	m, err := registrationMethod(via)
+	if err != nil {
+		return "", err
+	}
+
+	if m != authy.ViaMethodPush && !stdinIsTerminal() {
+		return "", fmt.Errorf("%w, the %s PIN can't be entered, register with --via push", ErrNoTTY, m)
+	}
+
+	return m, nil
+}
+
+// registrationClient Authy API calls registering a device, faked in tests
+type registrationClient interface {
+	RequestDeviceRegistration(ctx context.Context, userID uint64, via authy.ViaMethod) (authy.StartDeviceRegistrationResponse, error)
+	CheckDeviceRegistration(ctx context.Context, userID uint64, requestID string) (authy.DeviceRegistrationStatus, error)
+	CompleteDeviceRegistration(ctx context.Context, userID uint64, pin string) (authy.CompleteDeviceRegistrationResponse, error)
+}
+
+// registerDevice register with the user's approval, until ctx is done
+func registerDevice(ctx context.Context, client registrationClient, userStatus authy.UserStatus, via authy.ViaMethod, conf NewDeviceConfig) (resp authy.CompleteDeviceRegistrationResponse, err error) {
+	// Begin a device registration, the PIN comes from the Authy app push notification or by SMS/call
+	regStart, err := client.RequestDeviceRegistration(ctx, userStatus.AuthyID, via)
+	if err != nil {
+		err = registrationError(ctx, err)
+		log.Println("Start register device failed", err)
+		return
+	}
+
+	if !regStart.Success {
+		err = fmt.Errorf("Authy did not accept the device registration request: %+v", regStart)
+		log.Println(err)
+		return
+	}
+
+	var regPIN string
+	if via == authy.ViaMethodPush {
+		regPIN, err = waitForApproval(ctx, client, userStatus.AuthyID, regStart.RequestID, conf.PollInterval)
+	} else {
+		regPIN, err = readPIN(ctx, via)
+	}
+
+	if err != nil {
+		err = registrationError(ctx, err)
+		log.Println(err)
+		return
+	}
+
+	resp, err = client.CompleteDeviceRegistration(ctx, userStatus.AuthyID, regPIN)
+	if err != nil {
+		err = registrationError(ctx, err)
+		log.Println(err)
+		return
+	}
+
+	if resp.Device.SecretSeed == "" {
+		err = errors.New("Something went wrong completing the device registration")
+		log.Println(err)
+		return
+	}
+
+	return
+}
+
+// waitForApproval poll the registration request until it's accepted in the Authy app,
+// backing off from interval up to maxPollInterval
+func waitForApproval(ctx context.Context, client registrationClient, authyID uint64, requestID string, interval time.Duration) (string, error) {
+	p := startProgress(ctx, "Waiting for you to accept the device registration in your Authy app")
+	defer p.stop()
+
+	for {
+		regStatus, err := client.CheckDeviceRegistration(ctx, authyID, requestID)
+		if err != nil {
+			return "", err
+		}
+
+		switch regStatus.Status {
+		case "accepted":
+			return regStatus.PIN, nil
+		case "pending":
+		default:
+			return "", fmt.Errorf("Invalid status while waiting for device registration: %s", regStatus.Status)
+		}
+
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(interval):
+		}
+
+		if interval = interval * 3 / 2; interval > maxPollInterval {
+			interval = maxPollInterval
+		}
+	}
+}
+
+// readPIN PIN received by SMS or call, typed on the terminal
+func readPIN(ctx context.Context, via authy.ViaMethod) (string, error) {
+	fmt.Printf("\nEnter the registration PIN you received by %s: ", via)
+
+	line, err := readLine(ctx)
+	if err != nil && !errors.Is(err, io.EOF) {
+		fmt.Println()
+		return "", err
+	}
+
+	pin := strings.TrimSpace(line)
+	if len(pin) == 0 {
+		return "", errors.New("No registration PIN entered")
+	}
+
+	return pin, nil
+}
+
+// registrationError explain errors caused by the timeout or Ctrl-C
+func registrationError(ctx context.Context, err error) error {
+	switch ctx.Err() {
+	case context.DeadlineExceeded:
+		return errors.New("Gave up waiting for user to respond to Authy device registration request")
+	case context.Canceled:
+		return errors.New("Device registration cancelled")
+	}
+
+	return err
+}
+
+// progress one status line with the time left until the context deadline,
+// redrawn every second on a terminal and printed once otherwise
+type progress struct {
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+func startProgress(ctx context.Context, msg string) *progress {
+	p := &progress{done: make(chan struct{}), stopped: make(chan struct{})}
+
+	if !terminal.IsTerminal(int(os.Stderr.Fd())) {
+		fmt.Fprintln(os.Stderr, msg)
+		close(p.stopped)
+		return p
+	}
+
+	deadline, hasDeadline := ctx.Deadline()
+	draw := func() {
+		line := msg + " (Ctrl-C to cancel)"
+		if hasDeadline {
+			line = fmt.Sprintf("%s, %s left (Ctrl-C to cancel)", msg, time.Until(deadline).Truncate(time.Second))
+		}
+		// \033[K clears what's left of a longer previous line
+		fmt.Fprintf(os.Stderr, "\r%s\033[K", line)
+	}
+
+	go func() {
+		defer close(p.stopped)
+
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+
+		for draw(); ; draw() {
+			select {
+			case <-p.done:
+				fmt.Fprintln(os.Stderr)
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	return p
+}
+
+func (p *progress) stop() {
+	close(p.done)
+	<-p.stopped
+}
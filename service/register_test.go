@@ -0,0 +1,132 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alexzorin/authy"
+)
+
+// fakeRegistration answers status checks with statuses in turn, repeating the last one
+type fakeRegistration struct {
+	mu       sync.Mutex
+	statuses []authy.DeviceRegistrationStatus
+	checks   int
+}
+
+func (f *fakeRegistration) RequestDeviceRegistration(ctx context.Context, userID uint64, via authy.ViaMethod) (authy.StartDeviceRegistrationResponse, error) {
+	return authy.StartDeviceRegistrationResponse{Success: true, RequestID: "request"}, nil
+}
+
+func (f *fakeRegistration) CheckDeviceRegistration(ctx context.Context, userID uint64, requestID string) (authy.DeviceRegistrationStatus, error) {
+	if err := ctx.Err(); err != nil {
+		return authy.DeviceRegistrationStatus{}, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	i := f.checks
+	if i >= len(f.statuses) {
+		i = len(f.statuses) - 1
+	}
+	f.checks++
+
+	return f.statuses[i], nil
+}
+
+func (f *fakeRegistration) CompleteDeviceRegistration(ctx context.Context, userID uint64, pin string) (authy.CompleteDeviceRegistrationResponse, error) {
+	return authy.CompleteDeviceRegistrationResponse{}, nil
+}
+
+func (f *fakeRegistration) checked() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.checks
+}
+
+var (
+	pending  = authy.DeviceRegistrationStatus{Status: "pending", Success: true}
+	accepted = authy.DeviceRegistrationStatus{Status: "accepted", PIN: "1234", Success: true}
+	rejected = authy.DeviceRegistrationStatus{Status: "rejected", Success: true}
+)
+
+func TestWaitForApprovalAccepted(t *testing.T) {
+	client := &fakeRegistration{statuses: []authy.DeviceRegistrationStatus{pending, pending, accepted}}
+
+	pin, err := waitForApproval(context.Background(), client, 1, "request", time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if pin != "1234" {
+		t.Fatalf("got PIN %q, want 1234", pin)
+	}
+
+	if n := client.checked(); n != 3 {
+		t.Fatalf("checked %d times, want 3", n)
+	}
+}
+
+func TestWaitForApprovalDenied(t *testing.T) {
+	client := &fakeRegistration{statuses: []authy.DeviceRegistrationStatus{pending, rejected}}
+
+	_, err := waitForApproval(context.Background(), client, 1, "request", time.Millisecond)
+	if err == nil {
+		t.Fatal("denied registration was accepted")
+	}
+
+	if n := client.checked(); n != 2 {
+		t.Fatalf("checked %d times, want 2", n)
+	}
+}
+
+func TestWaitForApprovalTimeout(t *testing.T) {
+	client := &fakeRegistration{statuses: []authy.DeviceRegistrationStatus{pending}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err := waitForApproval(ctx, client, 1, "request", time.Millisecond)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, want context.DeadlineExceeded", err)
+	}
+
+	if err = registrationError(ctx, err); err.Error() != "Gave up waiting for user to respond to Authy device registration request" {
+		t.Fatalf("got %q", err)
+	}
+}
+
+func TestWaitForApprovalCancel(t *testing.T) {
+	client := &fakeRegistration{statuses: []authy.DeviceRegistrationStatus{pending}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		_, err := waitForApproval(ctx, client, 1, "request", time.Hour)
+		done <- err
+	}()
+
+	// cancelled while waiting for the next poll
+	for client.checked() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("got %v, want context.Canceled", err)
+		}
+
+		if err = registrationError(ctx, err); err.Error() != "Device registration cancelled" {
+			t.Fatalf("got %q", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("waitForApproval did not return after cancel")
+	}
+}
@@ -0,0 +1,63 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrDeviceRevoked Authy no longer accepts the device credentials
+var ErrDeviceRevoked = errors.New("Device revoked, run 'authy device reregister'")
+
+// DeviceRevokedError the device credentials were rejected, e.g. the device was
+// removed in the Authy app
+type DeviceRevokedError struct {
+	DeviceID uint64
+	// Message from Authy
+	Message string
+}
+
+func (e *DeviceRevokedError) Error() string {
+	return fmt.Sprintf("%v, Authy rejected device %d: %s", ErrDeviceRevoked, e.DeviceID, e.Message)
+}
+
+// Is matches ErrDeviceRevoked
+func (e *DeviceRevokedError) Is(target error) bool {
+	return target == ErrDeviceRevoked
+}
+
+// Revoked the last refresh or status check found the device revoked. Codes are
+// still served from the cache until the device is registered again
+func (d *Device) Revoked() bool {
+	return d.registration.Revoked
+}
+
+// setRevoked remember whether the device is revoked, local data is never cleared
+func (d *Device) setRevoked(revoked bool) {
+	if d.registration.Revoked == revoked {
+		return
+	}
+
+	d.registration.Revoked = revoked
+	d.SaveDeviceInfo()
+}
+
+// fetchError error of a rejected authenticated request, a *DeviceRevokedError
+// when probing the device credentials confirms they are no longer accepted. The
+// device is only marked revoked then, not when the probe fails
+func (d *Device) fetchError(ctx context.Context, what string, resp interface{}) error {
+	status, err := d.Status(ctx)
+	if err == nil && !status.Authorized {
+		return &DeviceRevokedError{DeviceID: d.registration.DeviceID, Message: status.Message}
+	}
+
+	return fmt.Errorf("%s %+v", what, resp)
+}
+
+// revokedNotice result shown first while the device is revoked
+func revokedNotice() Output {
+	return Output{
+		OTitle: "Device revoked — run authy device reregister",
+		Error:  fmt.Errorf("%w, codes come from the local cache", ErrDeviceRevoked),
+	}
+}
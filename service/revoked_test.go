@@ -0,0 +1,130 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestFetchErrorRevoked(t *testing.T) {
+	d, _ := testDevice(t, map[string]fakeResponse{"sync": apiDenied})
+
+	err := d.fetchError(context.Background(), "Fetch authenticator apps failed", nil)
+
+	var revoked *DeviceRevokedError
+	if !errors.As(err, &revoked) || !errors.Is(err, ErrDeviceRevoked) {
+		t.Fatalf("got %v, want a DeviceRevokedError", err)
+	}
+
+	if revoked.DeviceID != 2 || revoked.Message != "Device was removed" {
+		t.Fatalf("got %+v, want device 2 with Authy's message", revoked)
+	}
+
+	if !reload(t, d).Revoked() {
+		t.Fatal("revoked device wasn't marked revoked")
+	}
+}
+
+func TestFetchErrorOtherFailures(t *testing.T) {
+	for name, resp := range map[string]fakeResponse{
+		"server error": apiFailing,
+		"bad request":  {status: http.StatusBadRequest, body: apiResponse{Message: "Invalid locale"}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			d, _ := testDevice(t, map[string]fakeResponse{"sync": resp})
+
+			err := d.fetchError(context.Background(), "Fetch authenticator apps failed", nil)
+			if err == nil || errors.Is(err, ErrDeviceRevoked) {
+				t.Fatalf("got %v, want a fetch error", err)
+			}
+
+			if reload(t, d).Revoked() {
+				t.Fatal("device was marked revoked")
+			}
+		})
+	}
+}
+
+func TestFetchErrorUsesContext(t *testing.T) {
+	d, api := testDevice(t, map[string]fakeResponse{"sync": apiDenied})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := d.fetchError(ctx, "Fetch authenticator apps failed", nil); errors.Is(err, ErrDeviceRevoked) {
+		t.Fatalf("got %v after the context ended", err)
+	}
+
+	if api.requested("sync") {
+		t.Fatal("device was probed after the context ended")
+	}
+
+	if reload(t, d).Revoked() {
+		t.Fatal("device was marked revoked")
+	}
+}
+
+func TestPinnedKeepsRevokedMark(t *testing.T) {
+	token := Output{Token: &Token{Name: "GitHub"}, Code: "123456", RemainSecs: 20, Period: 30}
+
+	o := pinned([]Output{revokedNotice(), token})
+	if o.Token == nil || !o.Revoked {
+		t.Fatalf("got %+v, want the token marked revoked", o)
+	}
+
+	if text := statusText(o); !strings.HasSuffix(text, revokedMark) {
+		t.Fatalf("status %q isn't marked revoked", text)
+	}
+
+	if class := statusClass(o); class != StatusRevoked {
+		t.Fatalf("got class %q, want %q", class, StatusRevoked)
+	}
+
+	// status renderers pin the pinned result again
+	if again := pinned([]Output{o}); !again.Revoked {
+		t.Fatal("pinning again dropped the revoked mark")
+	}
+
+	if o = pinned([]Output{token}); o.Revoked {
+		t.Fatal("token marked revoked without the notice")
+	}
+
+	// the notice alone is still shown
+	if o = pinned([]Output{revokedNotice()}); !errors.Is(o.Error, ErrDeviceRevoked) {
+		t.Fatalf("got %+v, want the revoked notice", o)
+	}
+}
+
+func TestLaunchersShowRevokedNotice(t *testing.T) {
+	outputs := []Output{revokedNotice(), {Token: &Token{Name: "GitHub"}, Code: "123456", RemainSecs: 20, Period: 30}}
+
+	for _, format := range []string{FormatRofi, FormatDmenu, FormatFzf} {
+		renderer, err := NewRenderer(format, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var b bytes.Buffer
+		if err = renderer.Render(&b, outputs); err != nil {
+			t.Fatal(err)
+		}
+
+		lines := strings.Split(b.String(), "\n")
+		found := false
+		for _, line := range lines {
+			if strings.Contains(line, revokedNotice().OTitle) {
+				found = true
+				if len(SelectedKey(line)) > 0 {
+					t.Errorf("%s: the notice can be selected: %q", format, line)
+				}
+			}
+		}
+
+		if !found {
+			t.Errorf("%s: no revoked notice in %q", format, b.String())
+		}
+	}
+}
@@ -3,103 +3,310 @@ package service
 import (
 	"errors"
 	"fmt"
+	"log"
 	"sort"
+	"strings"
 	"time"
 
 	"github.com/momaek/authy/totp"
-	"github.com/sahilm/fuzzy"
 )
 
 // Searcher
 type Searcher struct {
-	isAlfred bool
-	keyword  string
+	renderer  Renderer
+	request   CodeRequest
+	copy      bool
+	clipboard ClipboardConfig
+	agent     *AgentClient
 	*Device
 }
 
-func (s *Searcher) showAll() bool {
-	return len(s.keyword) == 0 || s.keyword == ""
+// SearcherConfig new searcher config
+type SearcherConfig struct {
+	Keyword string
+	Sort    SortOrder
+	// Renderer defaults to pretty output
+	Renderer Renderer
+
+	// MinRemaining codes expiring sooner are replaced by the next one,
+	// or waited for when Wait is set
+	MinRemaining int
+	Wait         bool
+
+	// Copy put the code of the first result on the clipboard
+	Copy      bool
+	Clipboard ClipboardConfig
 }
 
 // NewSearcher ..
-func NewSearcher(keyword string, isAlfred bool) *Searcher {
+func NewSearcher(conf SearcherConfig) *Searcher {
+	if conf.Renderer == nil {
+		conf.Renderer = prettyRenderer{}
+	}
+
 	return &Searcher{
-		isAlfred: isAlfred,
-		keyword:  keyword,
-		Device:   NewDevice(NewDeviceConfig{}),
+		renderer: conf.Renderer,
+		request: CodeRequest{
+			Query:        conf.Keyword,
+			Sort:         conf.Sort,
+			MinRemaining: conf.MinRemaining,
+			Wait:         conf.Wait,
+		},
+		copy:      conf.Copy,
+		clipboard: conf.Clipboard,
+		agent:     AgentFromEnv(),
+		Device:    NewDevice(NewDeviceConfig{}),
 	}
 }
 
-// Search fuzzy search tokens by name and origin_name
+// Search search tokens with query, see Query for the syntax
 func (s *Searcher) Search() {
-	var (
-		tokens  = []*Token{}
-		outputs = []Output{}
-	)
+	outputs, err := s.findCodes()
 
-	s.Device.LoadTokenFromCache()
+	switch {
+	case errors.Is(err, ErrNoTokens):
+		outputs = []Output{{OTitle: "OTP tokens not found", Error: err}}
+	case errors.Is(err, ErrInvalidQuery):
+		outputs = []Output{{OTitle: "Invalid query", Error: err}}
+	case errors.Is(err, ErrAgentLocked):
+		outputs = []Output{{OTitle: "Authy agent is locked", Error: err}}
+	case err != nil:
+		outputs = []Output{{OTitle: "Search failed", Error: err}}
+	case len(outputs) == 0:
+		outputs = []Output{{
+			OTitle: fmt.Sprintf("OTP token not found (%s)", s.request.Query),
+			Error:  errors.New("Please try another keyword"),
+		}}
+	}
 
-	if s.showAll() {
-		sort.Sort(Tokens(s.Device.tokens))
-		tokens = s.Device.tokens
-	} else {
-		tokens = s.searchTokens()
+	if s.copy && len(outputs) > 0 && outputs[0].Token != nil {
+		if err := s.Device.CopyCode(outputs[0], s.clipboard); err != nil {
+			log.Println("Copy code failed", err)
+		}
 	}
 
-	if len(s.Device.tokens) == 0 {
-		outputs = append(outputs, Output{
-			OTitle: "OTP tokens not found",
-			Error:  errors.New("Please run 'authy refresh' in commandline"),
-		})
-	} else {
-		outputs = s.calcTokens(tokens)
+	if s.Device.Revoked() {
+		outputs = append([]Output{revokedNotice()}, outputs...)
 	}
 
 	s.showResult(outputs)
 }
 
-func (s *Searcher) searchTokens() []*Token {
-	results := fuzzy.FindFrom(s.keyword, Tokens(s.Device.tokens))
-	foundTokens := make([]*Token, 0, len(results))
-	for _, v := range results {
-		foundTokens = append(foundTokens, s.Device.tokens[v.Index])
-		s.Device.tokens[v.Index].updateWeight()
+// findCodes from the agent if one is running, otherwise from the local cache
+func (s *Searcher) findCodes() ([]Output, error) {
+	if s.agent != nil {
+		outputs, err := s.agent.FindCodes(s.request)
+		if !errors.Is(err, ErrAgentUnreachable) {
+			return outputs, err
+		}
+
+		log.Println(err)
 	}
-	s.Device.saveToken()
-	return foundTokens
+
+	s.Device.LoadTokenFromCache()
+	return s.Device.FindCodes(s.request)
 }
 
-func calcRemainSec(challenge int64) int {
-	return 30 - int(time.Now().Unix()-challenge*30)
+// CodeRequest which codes to compute
+type CodeRequest struct {
+	Query        string    `json:"query"`
+	Sort         SortOrder `json:"sort,omitempty"`
+	MinRemaining int       `json:"min_remaining,omitempty"`
+	Wait         bool      `json:"wait,omitempty"`
 }
 
-func (s *Searcher) calcTokens(tokens []*Token) []Output {
-	out := make([]Output, 0, len(tokens))
+var (
+	// ErrNoTokens token cache is empty
+	ErrNoTokens = errors.New("Please run 'authy refresh' in commandline")
+	// ErrInvalidQuery query can not be parsed
+	ErrInvalidQuery = errors.New("Invalid query")
+)
+
+// FindCodes codes of the tokens matching the query, ranked by the sort order
+func (d *Device) FindCodes(req CodeRequest) ([]Output, error) {
+	if len(d.tokens) == 0 {
+		return nil, ErrNoTokens
+	}
+
+	query, err := ParseQuery(req.Query)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidQuery, err)
+	}
+
+	tokens := d.rankTokens(query, req.Sort)
+
+	if req.Wait {
+		WaitForFresh(tokens, req.MinRemaining)
+	}
+
+	now := time.Now()
+	outputs := make([]Output, 0, len(tokens))
 	for _, tk := range tokens {
-		if len(tk.Secret) == 0 {
-			out = append(out, Output{
-				Token: tk,
-				Error: errors.New("OTP token is empty"),
-			})
-			continue
+		o := CalcTokenAt(tk, now, req.MinRemaining)
+		o.Highlights = query.Highlight(tk)
+		outputs = append(outputs, o)
+	}
+
+	return outputs, nil
+}
+
+// ResolveCode code of the only token matching the query
+func (d *Device) ResolveCode(req CodeRequest) (Output, error) {
+	tk, err := d.ResolveToken(req.Query)
+	if err != nil {
+		return Output{}, err
+	}
+
+	if req.Wait {
+		WaitForFresh([]*Token{tk}, req.MinRemaining)
+	}
+
+	out := CalcTokenAt(tk, time.Now(), req.MinRemaining)
+	return out, out.Error
+}
+
+// rankTokens tokens matching query, all of them ranked by order for an empty query,
+// otherwise best match first with order only breaking ties
+func (d *Device) rankTokens(query Query, order SortOrder) []*Token {
+	if query.IsEmpty() {
+		tokens := append([]*Token{}, d.tokens...)
+		d.sortTokens(tokens, SortName)
+		d.sortTokens(tokens, order)
+		return tokens
+	}
+
+	tokens, scores := d.matchTokens(query)
+	d.sortTokens(tokens, order)
+	sortByScore(tokens, scores)
+	return tokens
+}
+
+// searchTokens tokens matching query, best match first
+func (d *Device) searchTokens(query Query) []*Token {
+	tokens, scores := d.matchTokens(query)
+	sortByScore(tokens, scores)
+	return tokens
+}
+
+// matchTokens tokens matching query with their match scores
+func (d *Device) matchTokens(query Query) ([]*Token, map[*Token]int) {
+	var (
+		foundTokens = []*Token{}
+		scores      = map[*Token]int{}
+	)
+
+	for _, tk := range d.tokens {
+		if score, ok := query.Match(tk); ok {
+			foundTokens = append(foundTokens, tk)
+			scores[tk] = score
 		}
+	}
 
-		codes := totp.GetTotpCode(tk.Secret, tk.Digital)
-		challenge := totp.GetChallenge()
+	return foundTokens, scores
+}
+
+// sortByScore highest score first, ties keep their current order
+func sortByScore(tokens []*Token, scores map[*Token]int) {
+	sort.SliceStable(tokens, func(i, j int) bool {
+		return scores[tokens[i]] > scores[tokens[j]]
+	})
+}
 
-		out = append(out, Output{
-			Token:      tk,
-			Code:       codes[1],
-			RemainSecs: calcRemainSec(challenge),
-		})
+// ErrEmptySecret token has no secret to generate codes from
+var ErrEmptySecret = errors.New("OTP token is empty")
+
+// CalcToken current code of token
+func CalcToken(tk *Token) Output {
+	return CalcTokenAt(tk, time.Now(), 0)
+}
+
+// CalcTokenAt code of token at now, if it expires in less than minRemaining seconds
+// the code of the next period is returned instead
+func CalcTokenAt(tk *Token, now time.Time, minRemaining int) Output {
+	if len(tk.Secret) == 0 {
+		return Output{
+			Token: tk,
+			Error: ErrEmptySecret,
+		}
 	}
 
-	if len(out) == 0 {
-		out = append(out, Output{
-			OTitle: fmt.Sprintf("OTP token not found (%s)", s.keyword),
-			Error:  errors.New("Please try another keyword"),
-		})
+	period := tk.PeriodSecs()
+	code, remain, err := totp.CodeAt(tk.Secret, tk.Digits(), period, now)
+	if err != nil {
+		return Output{Token: tk, Error: err}
+	}
+
+	next, _, _ := totp.CodeAt(tk.Secret, tk.Digits(), period, now.Add(time.Duration(period)*time.Second))
+
+	out := Output{
+		Token:      tk,
+		Code:       code,
+		RemainSecs: remain,
+		Period:     period,
+		NextCode:   next,
+	}
+
+	if remain < effectiveMinRemaining(minRemaining, period) {
+		out.Code = next
+		out.NextCode = ""
+		out.ValidIn = remain
+		out.RemainSecs = remain + period
 	}
 
 	return out
 }
+
+// ErrTokenNotFound no token matches the query
+var ErrTokenNotFound = errors.New("OTP token not found")
+
+// AmbiguousTokenError more than one token matches the query
+type AmbiguousTokenError struct {
+	Query      string
+	Candidates []*Token
+}
+
+func (e *AmbiguousTokenError) Error() string {
+	titles := make([]string, 0, len(e.Candidates))
+	for _, tk := range e.Candidates {
+		titles = append(titles, tk.Title())
+	}
+
+	return fmt.Sprintf("%q matches %d tokens: %s", e.Query, len(e.Candidates), strings.Join(titles, ", "))
+}
+
+// ResolveToken find exactly one token by id, exact name or unambiguous query match
+func (d *Device) ResolveToken(query string) (*Token, error) {
+	if tk := d.FindToken(query); tk != nil {
+		return tk, nil
+	}
+
+	exact := []*Token{}
+	for _, tk := range d.tokens {
+		if strings.EqualFold(tk.Title(), query) || strings.EqualFold(tk.Name, query) || strings.EqualFold(tk.OriginalName, query) {
+			exact = append(exact, tk)
+		}
+	}
+
+	switch len(exact) {
+	case 0:
+	case 1:
+		return exact[0], nil
+	default:
+		return nil, &AmbiguousTokenError{Query: query, Candidates: exact}
+	}
+
+	q, err := ParseQuery(query)
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := d.searchTokens(q)
+	switch {
+	case q.IsEmpty() || len(candidates) == 0:
+		return nil, ErrTokenNotFound
+	case len(candidates) == 1:
+		return candidates[0], nil
+	}
+
+	return nil, &AmbiguousTokenError{Query: query, Candidates: candidates}
+}
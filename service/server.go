@@ -0,0 +1,252 @@
+package service
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// eventsPath of the Server-Sent Events, the only endpoint taking access_token
+const eventsPath = "/v1/events"
+
+// CodeSource computes codes, the local Device or a running agent
+type CodeSource interface {
+	FindCodes(req CodeRequest) ([]Output, error)
+	ResolveCode(req CodeRequest) (Output, error)
+}
+
+// Server HTTP/JSON API of codes, every request needs the bearer token
+//
+//	GET /v1/tokens              codes of all tokens
+//	GET /v1/search?q=query      codes of tokens matching the query
+//	GET /v1/code?q=query        code of exactly one token
+//	GET /v1/events?q=query      Server-Sent Events with new codes at each period rollover
+//
+// search, code and events also take sort and min_remaining like the fuzz command. They
+// never wait for a fresh code, min_remaining returns the next period's code instead.
+// Browsers can't set headers on EventSource, so events also accepts the token as access_token
+// parameter. URLs end up in logs and histories, the other endpoints only take the header
+type Server struct {
+	source CodeSource
+	token  string
+	mux    *http.ServeMux
+}
+
+// NewServer ..
+func NewServer(source CodeSource, token string) *Server {
+	s := &Server{
+		source: source,
+		token:  token,
+		mux:    http.NewServeMux(),
+	}
+
+	s.mux.HandleFunc("/v1/tokens", s.handleTokens)
+	s.mux.HandleFunc("/v1/search", s.handleSearch)
+	s.mux.HandleFunc("/v1/code", s.handleCode)
+	s.mux.HandleFunc(eventsPath, s.handleEvents)
+
+	return s
+}
+
+// GenerateServerToken random bearer token
+func GenerateServerToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="authy"`)
+		writeError(w, http.StatusUnauthorized, errors.New("Invalid or missing bearer token"))
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("Method %s not allowed", r.Method))
+		return
+	}
+
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) authorized(r *http.Request) bool {
+	token := ""
+	if r.URL.Path == eventsPath {
+		token = r.URL.Query().Get("access_token")
+	}
+
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		token = strings.TrimPrefix(auth, "Bearer ")
+	}
+
+	return len(token) > 0 && subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) == 1
+}
+
+// codeRequest request from the query parameters
+func codeRequest(r *http.Request) (req CodeRequest, err error) {
+	params := r.URL.Query()
+	req.Query = params.Get("q")
+
+	if req.Sort, err = ParseSortOrder(params.Get("sort")); err != nil {
+		return
+	}
+
+	if v := params.Get("min_remaining"); len(v) > 0 {
+		if req.MinRemaining, err = strconv.Atoi(v); err != nil {
+			return req, fmt.Errorf("Invalid min_remaining %q", v)
+		}
+	}
+
+	if params.Has("wait") {
+		return req, errors.New("wait is not supported, min_remaining returns the next period's code")
+	}
+
+	return
+}
+
+func (s *Server) handleTokens(w http.ResponseWriter, r *http.Request) {
+	outputs, err := s.source.FindCodes(CodeRequest{})
+	if err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, records(outputs))
+}
+
+func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
+	req, err := codeRequest(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	outputs, err := s.source.FindCodes(req)
+	if err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, records(outputs))
+}
+
+func (s *Server) handleCode(w http.ResponseWriter, r *http.Request) {
+	req, err := codeRequest(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	out, err := s.source.ResolveCode(req)
+	if err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, out.Record())
+}
+
+// handleEvents push the codes now and again whenever one of them rolls over
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	req, err := codeRequest(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, errors.New("Streaming not supported"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	for {
+		outputs, err := s.source.FindCodes(req)
+		if err != nil {
+			writeEvent(w, "error", map[string]string{"error": err.Error()})
+			flusher.Flush()
+			return
+		}
+
+		writeEvent(w, "codes", records(outputs))
+		flusher.Flush()
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-time.After(nextRollover(outputs, time.Now())):
+		}
+	}
+}
+
+// nextRollover time until the first of outputs gets a new code
+func nextRollover(outputs []Output, now time.Time) time.Duration {
+	next := time.Duration(0)
+	for _, o := range outputs {
+		if o.Error != nil || o.Period <= 0 {
+			continue
+		}
+
+		period := int64(o.Period)
+		d := time.Unix((now.Unix()/period+1)*period, 0).Sub(now)
+		if next == 0 || d < next {
+			next = d
+		}
+	}
+
+	if next == 0 {
+		return 30 * time.Second
+	}
+
+	return next
+}
+
+func errorStatus(err error) int {
+	var ambiguous *AmbiguousTokenError
+	switch {
+	case errors.Is(err, ErrInvalidQuery):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrTokenNotFound), errors.Is(err, ErrNoTokens):
+		return http.StatusNotFound
+	case errors.As(err, &ambiguous):
+		return http.StatusConflict
+	case errors.Is(err, ErrEmptySecret):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, ErrAgentLocked):
+		return http.StatusLocked
+	case errors.Is(err, ErrAgentUnreachable):
+		return http.StatusServiceUnavailable
+	}
+
+	return http.StatusInternalServerError
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func writeEvent(w http.ResponseWriter, event string, v interface{}) {
+	b, _ := json.Marshal(v)
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, b)
+}
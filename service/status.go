@@ -0,0 +1,217 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Status bar output formats, they show the first result only
+const (
+	FormatWaybar   = "waybar"
+	FormatI3blocks = "i3blocks"
+	FormatPolybar  = "polybar"
+	FormatTmux     = "tmux"
+	FormatRaycast  = "raycast"
+)
+
+// expiringColor color of codes about to expire
+const expiringColor = "#FF5555"
+
+// Status classes
+const (
+	StatusOK       = "ok"
+	StatusExpiring = "expiring"
+	StatusError    = "error"
+	StatusRevoked  = "revoked"
+)
+
+// revokedMark appended to the status line while the device is revoked
+const revokedMark = " (device revoked)"
+
+// pinned first token result, the one status bars show, else the first notice. The
+// revoked notice is folded into the result as Revoked instead of replacing it
+func pinned(outputs []Output) Output {
+	if len(outputs) == 0 {
+		return Output{OTitle: "authy", Error: ErrTokenNotFound}
+	}
+
+	revoked := false
+	rest := make([]Output, 0, len(outputs))
+	for _, o := range outputs {
+		if isRevokedNotice(o) {
+			revoked = true
+			continue
+		}
+
+		rest = append(rest, o)
+	}
+
+	if len(rest) == 0 {
+		return outputs[0]
+	}
+
+	p := rest[0]
+	for _, o := range rest {
+		if o.Token != nil {
+			p = o
+			break
+		}
+	}
+
+	if revoked {
+		p.Revoked = true
+	}
+
+	return p
+}
+
+// isRevokedNotice o is the notice added while the device is revoked
+func isRevokedNotice(o Output) bool {
+	return o.Token == nil && errors.Is(o.Error, ErrDeviceRevoked)
+}
+
+// PinnedRenderer renders only the first result with r
+func PinnedRenderer(r Renderer) Renderer {
+	return pinnedRenderer{r}
+}
+
+type pinnedRenderer struct {
+	Renderer
+}
+
+func (p pinnedRenderer) Render(w io.Writer, outputs []Output) error {
+	return p.Renderer.Render(w, []Output{pinned(outputs)})
+}
+
+// statusClass ok, expiring when the code expires within nearExpirySecs, revoked
+// when it comes from the cache of a revoked device, or error
+func statusClass(o Output) string {
+	switch {
+	case o.Error != nil:
+		return StatusError
+	case o.Revoked:
+		return StatusRevoked
+	case o.ValidIn == 0 && o.RemainSecs <= nearExpirySecs:
+		return StatusExpiring
+	}
+
+	return StatusOK
+}
+
+// statusText short status line of o
+func statusText(o Output) string {
+	var text string
+	switch {
+	case o.Error != nil:
+		text = fmt.Sprintf("%s: %v", o.Title(), o.Error)
+	case o.ValidIn > 0:
+		text = fmt.Sprintf("%s %s in %ds", o.Title(), o.Code, o.ValidIn)
+	default:
+		text = fmt.Sprintf("%s %s %ds", o.Title(), o.Code, o.RemainSecs)
+	}
+
+	if o.Revoked {
+		text += revokedMark
+	}
+
+	return text
+}
+
+// WaybarStatus output of a Waybar custom module with "return-type": "json"
+type WaybarStatus struct {
+	Text       string `json:"text"`
+	Tooltip    string `json:"tooltip"`
+	Class      string `json:"class"`
+	Percentage int    `json:"percentage"`
+}
+
+type waybarRenderer struct{}
+
+func (waybarRenderer) Render(w io.Writer, outputs []Output) error {
+	o := pinned(outputs)
+
+	status := WaybarStatus{
+		Text:    statusText(o),
+		Tooltip: o.AfredSubtitle(),
+		Class:   statusClass(o),
+	}
+
+	if o.Error == nil && o.Period > 0 {
+		status.Percentage = o.RemainSecs * 100 / o.Period
+	}
+
+	return json.NewEncoder(w).Encode(status)
+}
+
+// i3blocksRenderer full text, short text and color lines
+type i3blocksRenderer struct{}
+
+func (i3blocksRenderer) Render(w io.Writer, outputs []Output) error {
+	o := pinned(outputs)
+
+	short := o.Code
+	if o.Error != nil {
+		short = "authy: error"
+	}
+
+	color := ""
+	if statusClass(o) != StatusOK {
+		color = expiringColor
+	}
+
+	_, err := fmt.Fprintf(w, "%s\n%s\n%s\n", statusText(o), short, color)
+	return err
+}
+
+// polybarRenderer one line, colored with polybar format tags near expiry
+type polybarRenderer struct{}
+
+func (polybarRenderer) Render(w io.Writer, outputs []Output) error {
+	o := pinned(outputs)
+
+	// % starts a polybar format tag
+	text := strings.ReplaceAll(statusText(o), "%", "%%")
+	if statusClass(o) != StatusOK {
+		text = "%{F" + expiringColor + "}" + text + "%{F-}"
+	}
+
+	_, err := fmt.Fprintln(w, text)
+	return err
+}
+
+// tmuxRenderer status-line segment, e.g. status-right '#(authy status -o tmux)'
+type tmuxRenderer struct{}
+
+func (tmuxRenderer) Render(w io.Writer, outputs []Output) error {
+	o := pinned(outputs)
+
+	// # starts a tmux format
+	text := strings.ReplaceAll(statusText(o), "#", "##")
+	if statusClass(o) != StatusOK {
+		text = "#[fg=red]" + text + "#[default]"
+	}
+
+	_, err := fmt.Fprintln(w, text)
+	return err
+}
+
+// raycastRenderer script command output, inline mode shows the first line,
+// fullOutput mode all of them
+type raycastRenderer struct{}
+
+func (raycastRenderer) Render(w io.Writer, outputs []Output) error {
+	if len(outputs) == 0 {
+		outputs = []Output{pinned(outputs)}
+	}
+
+	for _, o := range outputs {
+		if _, err := fmt.Fprintln(w, statusText(o)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -1,49 +1,85 @@
 package service
 
 import (
+	"context"
 	"crypto/md5"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"strings"
 
 	"github.com/alexzorin/authy"
-	"golang.org/x/crypto/ssh/terminal"
+	"github.com/momaek/authy/totp"
 )
 
-// Tokens for sort
-type Tokens []*Token
+const defaultDigits = 6
 
-func (t Tokens) Less(i, j int) bool {
-	return t[i].Weight > t[j].Weight
-}
+// maxPasswordAttempts times the backup password is asked before giving up
+const maxPasswordAttempts = 3
 
-func (t Tokens) Len() int {
-	return len(t)
-}
+// ErrWrongPassword the backup password doesn't decrypt the tokens
+var ErrWrongPassword = errors.New("Wrong backup password")
 
-func (t Tokens) Swap(i, j int) {
-	t[i], t[j] = t[j], t[i]
-}
-
-// String implement fuzz search
-func (t Tokens) String(i int) string {
-	return t[i].Name + t[i].OriginalName
-}
+// ErrNoTokenToCheck none of the tokens can be decrypted to check the backup password
+var ErrNoTokenToCheck = errors.New("No token to check the password against")
 
 // Token ..
 type Token struct {
+	ID           string `json:"id,omitempty"`
 	Name         string `json:"name"`
 	OriginalName string `json:"original_name"`
 	Digital      int    `json:"digital"`
 	Secret       string `json:"secret"`
 	Period       int    `json:"period"`
-	Weight       int    `json:"weight"`
+
+	Issuer  string `json:"issuer,omitempty"`
+	Account string `json:"account,omitempty"`
+
+	// Set locally, kept across refreshes
+	Alias    string   `json:"alias,omitempty"`
+	Tags     []string `json:"tags,omitempty"`
+	Notes    string   `json:"notes,omitempty"`
+	Favorite bool     `json:"favorite,omitempty"`
+
+	// Deprecated: usage is tracked in UsageStore, only read to migrate old caches
+	Weight int `json:"weight,omitempty"`
+}
+
+// Key stable identifier of token
+func (t Token) Key() string {
+	if len(t.ID) > 0 {
+		return t.ID
+	}
+
+	return generateMD5(&t)
+}
+
+// PeriodSecs seconds each code is valid
+func (t Token) PeriodSecs() int {
+	if t.Period > 0 {
+		return t.Period
+	}
+
+	return totp.INTERVAL
+}
+
+// Digits code length
+func (t Token) Digits() int {
+	if t.Digital > 0 {
+		return t.Digital
+	}
+
+	return defaultDigits
 }
 
 // Title show string
 func (t Token) Title() string {
+	if len(t.Alias) > 0 {
+		return t.Alias
+	}
+
 	if t.Name != t.OriginalName || len(t.OriginalName) == 0 {
 		return t.Name
 	}
@@ -51,8 +87,24 @@ func (t Token) Title() string {
 	return t.OriginalName
 }
 
-func (t *Token) updateWeight() {
-	t.Weight++
+// OTPAuthURI otpauth://totp/ URI to add the token to another authenticator, empty without secret
+func (t Token) OTPAuthURI() string {
+	if len(t.Secret) == 0 {
+		return ""
+	}
+
+	account := t.Account
+	if len(account) == 0 {
+		account = t.Title()
+	}
+
+	return totp.Key{
+		Secret:  t.Secret,
+		Issuer:  t.Issuer,
+		Account: account,
+		Digits:  t.Digits(),
+		Period:  t.PeriodSecs(),
+	}.URI()
 }
 
 // LoadTokenFromCache load token from local cache
@@ -64,104 +116,190 @@ func (d *Device) LoadTokenFromCache() (err error) {
 		}
 	}()
 
-	fpath, err := d.ConfigPath(d.conf.CacheFileName)
+	tks, err := d.readTokenCache()
 	if err != nil {
 		return
 	}
 
-	f, err := os.Open(fpath)
+	for _, tk := range tks {
+		tk.fillIssuerAccount()
+	}
+
+	d.tokens = tks
+	d.tokenMap = tokensToMap(d.tokens)
+
+	return
+}
+
+// Tokens loaded tokens
+func (d *Device) Tokens() []*Token {
+	return d.tokens
+}
+
+func (d *Device) readTokenCache() (tks []*Token, err error) {
+	fpath, err := d.ConfigPath(d.conf.CacheFileName)
 	if err != nil {
 		return
 	}
 
-	defer f.Close()
-	err = json.NewDecoder(f).Decode(&d.tokens)
+	f, err := os.Open(fpath)
 	if err != nil {
 		return
 	}
 
-	d.tokenMap = tokensToMap(d.tokens)
-
+	defer f.Close()
+	err = json.NewDecoder(f).Decode(&tks)
 	return
 }
 
 // LoadTokenFromAuthyServer load token from authy server, make sure that you've enabled Authenticator Backups And Multi-Device Sync
 func (d *Device) LoadTokenFromAuthyServer() {
-	client, err := authy.NewClient()
-	if err != nil {
-		log.Fatalf("Create authy API client failed %+v", err)
-	}
-
-	apps, err := client.QueryAuthenticatorApps(nil, d.registration.UserID, d.registration.DeviceID, d.registration.Seed)
+	result, err := d.Refresh(context.Background())
 	if err != nil {
-		log.Fatalf("Fetch authenticator apps failed %+v", err)
+		log.Fatal(err)
 	}
 
-	if !apps.Success {
-		log.Fatalf("Fetch authenticator apps failed %+v", apps)
+	if len(result.Failures) > 0 {
+		result.WriteReport(os.Stderr)
 	}
+}
 
-	tokens, err := client.QueryAuthenticatorTokens(nil, d.registration.UserID, d.registration.DeviceID, d.registration.Seed)
+func (d *Device) queryAuthenticatorTokens(ctx context.Context, client authy.Client) (authy.AuthenticatorTokensResponse, error) {
+	tokens, err := client.QueryAuthenticatorTokens(ctx, d.registration.UserID, d.registration.DeviceID, d.registration.Seed)
 	if err != nil {
-		log.Fatalf("Fetch authenticator tokens failed %+v", err)
+		return tokens, fmt.Errorf("Fetch authenticator tokens failed %+v", err)
 	}
 
 	if !tokens.Success {
-		log.Fatalf("Fetch authenticator tokens failed %+v", tokens)
+		return tokens, d.fetchError(ctx, "Fetch authenticator tokens failed", tokens)
 	}
 
-	mainpwd := d.getMainPassword()
-
-	tks := []*Token{}
-	for _, v := range tokens.AuthenticatorTokens {
-		secret, err := v.Decrypt(mainpwd)
+	return tokens, nil
+}
 
+// getMainPassword backup password given by flag, environment, file or command,
+// else the saved one, else asked on the terminal up to maxPasswordAttempts times,
+// with the token it was checked against. Only a password that decrypts one of the
+// tokens is saved, one that can't be checked is used without being saved
+func (d *Device) getMainPassword(tks []authy.AuthenticatorToken) (string, authy.AuthenticatorToken, error) {
+	if d.conf.hasPassword() {
+		pwd, err := d.conf.password()
 		if err != nil {
-			log.Printf("Decryption failed for [%s]: %v", v.Name, err)
-			continue
+			return "", authy.AuthenticatorToken{}, err
 		}
 
-		tks = append(tks, &Token{
-			Name:         v.Name,
-			OriginalName: v.OriginalName,
-			Digital:      v.Digits,
-			Secret:       secret,
-		})
+		ref, err := checkMainPassword(tks, pwd)
+		if errors.Is(err, ErrNoTokenToCheck) {
+			err = nil
+		}
+		return pwd, ref, err
 	}
 
-	for _, v := range apps.AuthenticatorApps {
-		secret, err := v.Token()
-		if err != nil {
-			log.Fatal("Get secret from app failed", err)
+	if len(d.registration.MainPassword) > 0 {
+		ref, err := checkMainPassword(tks, d.registration.MainPassword)
+		if err == nil || errors.Is(err, ErrNoTokenToCheck) {
+			return d.registration.MainPassword, ref, nil
 		}
 
-		tks = append(tks, &Token{
-			Name:    v.Name,
-			Digital: v.Digits,
-			Secret:  secret,
-			Period:  10,
-		})
+		log.Printf("Saved password: %v", err)
 	}
 
-	d.tokenMap = tokensToMap(tks)
-	d.tokens = tks
-	d.saveToken()
-	return
-}
+	for attempt := 1; ; attempt++ {
+		pwd, err := promptPassword()
+		if err != nil {
+			return "", authy.AuthenticatorToken{}, fmt.Errorf("Get password failed: %w", err)
+		}
 
-func (d *Device) getMainPassword() string {
-	if len(d.registration.MainPassword) == 0 {
-		fmt.Print("\nPlease input Authy main password: ")
-		pp, err := terminal.ReadPassword(int(os.Stdin.Fd()))
+		ref, err := checkMainPassword(tks, pwd)
+		if errors.Is(err, ErrNoTokenToCheck) {
+			return pwd, ref, nil
+		}
 		if err != nil {
-			log.Fatalf("Get password failed %+v", err)
+			if attempt == maxPasswordAttempts {
+				return "", ref, err
+			}
+
+			fmt.Printf("%v, please try again\n", err)
+			continue
 		}
 
-		d.registration.MainPassword = strings.TrimSpace(string(pp))
+		d.registration.MainPassword = pwd
 		d.SaveDeviceInfo()
+		return pwd, ref, nil
+	}
+}
+
+// SaveMainPassword save the backup password given by flag, environment, file or
+// command, so later refreshes don't need it. It is only saved once it decrypted one
+// of the tokens, ErrNoTokenToCheck when there is none to try
+func (d *Device) SaveMainPassword(ctx context.Context) error {
+	pwd, err := d.conf.password()
+	if err != nil {
+		return err
+	}
+
+	if len(pwd) == 0 {
+		return errors.New("No password given")
+	}
+
+	client, err := authy.NewClient()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, fetchTimeout)
+	defer cancel()
+
+	tokens, err := d.queryAuthenticatorTokens(ctx, client)
+	if err != nil {
+		return err
 	}
 
-	return d.registration.MainPassword
+	if _, err = checkMainPassword(tokens.AuthenticatorTokens, pwd); err != nil {
+		return err
+	}
+
+	d.registration.MainPassword = pwd
+	return d.SaveDeviceInfo()
+}
+
+// checkMainPassword decrypt the tokens with pwd until one succeeds and return it, the
+// others may be encrypted with an older password. A wrong password fails the padding
+// check, or in the rare case it passes yields a secret that isn't base32.
+// ErrNoTokenToCheck when no token has a seed to decrypt
+func checkMainPassword(tks []authy.AuthenticatorToken, pwd string) (authy.AuthenticatorToken, error) {
+	checked := 0
+	for _, v := range tks {
+		if checkSeed(v) != nil {
+			continue
+		}
+
+		checked++
+		if secret, err := v.Decrypt(pwd); err == nil && isBase32(secret) {
+			return v, nil
+		}
+	}
+
+	if checked == 0 {
+		return authy.AuthenticatorToken{}, ErrNoTokenToCheck
+	}
+
+	return authy.AuthenticatorToken{}, fmt.Errorf("%w, it can't decrypt any of your %d token(s)", ErrWrongPassword, checked)
+}
+
+func isBase32(s string) bool {
+	s = strings.TrimRight(s, "=")
+	if len(s) == 0 {
+		return false
+	}
+
+	for _, c := range s {
+		if (c < 'A' || c > 'Z') && (c < '2' || c > '7') {
+			return false
+		}
+	}
+
+	return true
 }
 
 func generateMD5(tk *Token) string {
@@ -177,6 +315,11 @@ func tokensToMap(tks []*Token) map[string]*Token {
 	return ret
 }
 
+// SaveTokens persist token changes to local cache
+func (d *Device) SaveTokens() {
+	d.saveToken()
+}
+
 func (d *Device) saveToken() {
 	regrPath, err := d.ConfigPath(cacheFileName)
 	if err != nil {
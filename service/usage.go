@@ -0,0 +1,253 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const usageFileName = ".authyusage.json"
+
+// frecencyHalfLife time after which a recorded use counts half as much
+const frecencyHalfLife = 7 * 24 * time.Hour
+
+// SortOrder how search results are ranked
+type SortOrder string
+
+const (
+	// SortFrecency most frequently and recently used first
+	SortFrecency SortOrder = "frecency"
+	// SortName alphabetical by title
+	SortName SortOrder = "name"
+	// SortIssuer alphabetical by issuer, then by title
+	SortIssuer SortOrder = "issuer"
+	// SortRecent most recently used first
+	SortRecent SortOrder = "recent"
+)
+
+// SortOrders all supported sort orders
+var SortOrders = []SortOrder{SortFrecency, SortName, SortIssuer, SortRecent}
+
+// ParseSortOrder parse sort order from command line, empty means frecency
+func ParseSortOrder(s string) (SortOrder, error) {
+	if len(s) == 0 {
+		return SortFrecency, nil
+	}
+
+	for _, o := range SortOrders {
+		if strings.EqualFold(s, string(o)) {
+			return o, nil
+		}
+	}
+
+	return "", fmt.Errorf("Invalid sort order %q, must be one of %v", s, SortOrders)
+}
+
+// Usage usage statistics of one token
+type Usage struct {
+	Count    int     `json:"count"`
+	Score    float64 `json:"score"`
+	LastUsed int64   `json:"last_used"`
+}
+
+// Frecency usage score decayed to now
+func (u Usage) Frecency(now time.Time) float64 {
+	if u.LastUsed == 0 {
+		return 0
+	}
+
+	elapsed := now.Sub(time.Unix(u.LastUsed, 0))
+	if elapsed < 0 {
+		elapsed = 0
+	}
+
+	return u.Score * math.Pow(0.5, float64(elapsed)/float64(frecencyHalfLife))
+}
+
+func (u *Usage) record(now time.Time) {
+	u.Score = u.Frecency(now) + 1
+	u.Count++
+	u.LastUsed = now.Unix()
+}
+
+// UsageStore usage statistics keyed by token id, kept apart from the token cache
+type UsageStore map[string]*Usage
+
+// Get usage of token, zero value if never used
+func (s UsageStore) Get(tk *Token) Usage {
+	if u, ok := s[tk.Key()]; ok {
+		return *u
+	}
+
+	return Usage{}
+}
+
+func (d *Device) usagePath() (string, error) {
+	return d.ConfigPath(d.conf.UsageFileName)
+}
+
+// LoadUsage load usage statistics from local file
+func (d *Device) LoadUsage() UsageStore {
+	if d.usage != nil {
+		return d.usage
+	}
+
+	d.usage = UsageStore{}
+
+	fpath, err := d.usagePath()
+	if err != nil {
+		return d.usage
+	}
+
+	f, err := os.Open(fpath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			d.migrateWeight()
+		}
+		return d.usage
+	}
+
+	defer f.Close()
+	if err = json.NewDecoder(f).Decode(&d.usage); err != nil {
+		log.Println("Decode usage statistics failed", err)
+		d.usage = UsageStore{}
+	}
+
+	return d.usage
+}
+
+// migrateWeight seed usage statistics from the weight counter older versions kept in the token cache
+func (d *Device) migrateWeight() {
+	now := time.Now().Unix()
+	for _, tk := range d.tokens {
+		if tk.Weight == 0 {
+			continue
+		}
+
+		d.usage[tk.Key()] = &Usage{
+			Count:    tk.Weight,
+			Score:    float64(tk.Weight),
+			LastUsed: now,
+		}
+	}
+
+	if len(d.usage) > 0 {
+		if err := d.saveUsage(); err != nil {
+			log.Println("Save usage statistics failed", err)
+		}
+	}
+}
+
+func (d *Device) saveUsage() (err error) {
+	fpath, err := d.usagePath()
+	if err != nil {
+		return
+	}
+
+	f, err := os.OpenFile(fpath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+
+	defer f.Close()
+	err = json.NewEncoder(f).Encode(d.usage)
+	return
+}
+
+// migrateUsageKeys move usage recorded under the key of a cached copy, e.g. the md5
+// key of caches written before tokens had an id, to the key of the fresh token
+func (d *Device) migrateUsageKeys(tks, cached []*Token) {
+	usage := d.LoadUsage()
+
+	moved := false
+	for tk, o := range cachedCopies(tks, cached) {
+		u, ok := usage[o.Key()]
+		if !ok || o.Key() == tk.Key() {
+			continue
+		}
+
+		if _, exists := usage[tk.Key()]; !exists {
+			usage[tk.Key()] = u
+		}
+		delete(usage, o.Key())
+		moved = true
+	}
+
+	if moved {
+		if err := d.saveUsage(); err != nil {
+			log.Println("Save usage statistics failed", err)
+		}
+	}
+}
+
+// RecordUsage record that the code of token was actually used
+func (d *Device) RecordUsage(tk *Token) {
+	usage := d.LoadUsage()
+	u, ok := usage[tk.Key()]
+	if !ok {
+		u = &Usage{}
+		usage[tk.Key()] = u
+	}
+
+	u.record(time.Now())
+	if err := d.saveUsage(); err != nil {
+		log.Println("Save usage statistics failed", err)
+	}
+}
+
+// FindToken find token by key
+func (d *Device) FindToken(key string) *Token {
+	for _, tk := range d.tokens {
+		if tk.Key() == key {
+			return tk
+		}
+	}
+
+	return nil
+}
+
+// sortTokens rank tokens in place, favorites first, ties keep their current order
+func (d *Device) sortTokens(tokens []*Token, order SortOrder) {
+	var (
+		usage = d.LoadUsage()
+		now   = time.Now()
+		less  func(a, b *Token) bool
+	)
+
+	switch order {
+	case SortName:
+		less = lessByTitle
+	case SortIssuer:
+		less = func(a, b *Token) bool {
+			ia, ib := strings.ToLower(a.Issuer), strings.ToLower(b.Issuer)
+			if ia != ib {
+				return ia < ib
+			}
+			return lessByTitle(a, b)
+		}
+	case SortRecent:
+		less = func(a, b *Token) bool {
+			return usage.Get(a).LastUsed > usage.Get(b).LastUsed
+		}
+	default:
+		less = func(a, b *Token) bool {
+			return usage.Get(a).Frecency(now) > usage.Get(b).Frecency(now)
+		}
+	}
+
+	sort.SliceStable(tokens, func(i, j int) bool {
+		if tokens[i].Favorite != tokens[j].Favorite {
+			return tokens[i].Favorite
+		}
+		return less(tokens[i], tokens[j])
+	})
+}
+
+func lessByTitle(a, b *Token) bool {
+	return strings.ToLower(a.Title()) < strings.ToLower(b.Title())
+}
@@ -0,0 +1,24 @@
+package service
+
+import (
+	"strings"
+	"time"
+
+	"github.com/momaek/authy/totp"
+)
+
+// VerifyCode check code against token at now, allowing skew periods of clock drift
+// each way, returns the period offset that matched
+func VerifyCode(tk *Token, code string, skew int, now time.Time) (offset int, ok bool, err error) {
+	if len(tk.Secret) == 0 {
+		return 0, false, ErrEmptySecret
+	}
+
+	v := totp.Verifier{
+		Digits: tk.Digits(),
+		Period: tk.PeriodSecs(),
+		Skew:   skew,
+	}
+
+	return v.Verify(tk.Secret, strings.Join(strings.Fields(code), ""), now)
+}
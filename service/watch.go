@@ -0,0 +1,444 @@
+package service
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+const (
+	enterScreen = "\033[?1049h\033[?25l"
+	leaveScreen = "\033[?25h\033[?1049l"
+	clearScreen = "\033[H\033[2J"
+	clearLine   = "\033[K"
+	reverse     = "\033[7m"
+	red         = "\033[31m"
+	defaultFg   = "\033[39m"
+	reset       = "\033[0m"
+
+	barWidth = 20
+	// first screen line of the token list, and its first column of the countdown bar
+	listLine = 4
+	barCol   = 3
+	// lines besides the token list: header, filter, blank and status
+	chromeLines = 4
+)
+
+// Watcher full-screen live view of codes with type-to-filter
+type Watcher struct {
+	*Device
+	sort      SortOrder
+	clipboard ClipboardConfig
+	agent     *AgentClient
+
+	query    []rune
+	tokens   []*Token
+	outputs  []Output
+	selected int
+	offset   int
+	status   string
+
+	fd            int
+	width, height int
+	lastSecond    int64
+	drawnAt       time.Time
+	out           *bufio.Writer
+}
+
+// NewWatcher ..
+func NewWatcher(query string, order SortOrder, clipboard ClipboardConfig) *Watcher {
+	return &Watcher{
+		Device:    NewDevice(NewDeviceConfig{}),
+		sort:      order,
+		clipboard: clipboard,
+		agent:     AgentFromEnv(),
+		query:     []rune(query),
+		fd:        int(os.Stdin.Fd()),
+		out:       bufio.NewWriter(os.Stdout),
+	}
+}
+
+// Run until Esc or Ctrl-C is pressed
+func (w *Watcher) Run() error {
+	if !terminal.IsTerminal(w.fd) {
+		return errors.New("authy watch needs an interactive terminal")
+	}
+
+	if w.agent == nil {
+		w.Device.LoadTokenFromCache()
+	}
+
+	state, err := terminal.MakeRaw(w.fd)
+	if err != nil {
+		return err
+	}
+	defer terminal.Restore(w.fd, state)
+
+	w.out.WriteString(enterScreen)
+	defer func() {
+		w.out.WriteString(leaveScreen)
+		w.out.Flush()
+	}()
+
+	keys := make(chan []byte)
+	go readKeys(os.Stdin, keys)
+
+	w.filter()
+	w.redraw()
+
+	ticker := time.NewTicker(250 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case b, ok := <-keys:
+			if ok && b[len(b)-1] == 27 {
+				// an arrow key sequence may arrive split, a lone Esc does not continue
+				select {
+				case more := <-keys:
+					b = append(b, more...)
+				case <-time.After(50 * time.Millisecond):
+				}
+			}
+
+			if !ok || w.handleKeys(b) {
+				return nil
+			}
+			w.redraw()
+		case now := <-ticker.C:
+			w.tick(now)
+		}
+	}
+}
+
+func readKeys(r io.Reader, keys chan<- []byte) {
+	defer close(keys)
+
+	buf := make([]byte, 64)
+	for {
+		n, err := r.Read(buf)
+		if err != nil {
+			return
+		}
+
+		keys <- append([]byte{}, buf[:n]...)
+	}
+}
+
+// handleKeys apply key presses, returns true to quit
+func (w *Watcher) handleKeys(b []byte) bool {
+	filterChanged := false
+	for i := 0; i < len(b); i++ {
+		switch c := b[i]; c {
+		case 3: // Ctrl-C
+			return true
+		case 27: // Esc, or the start of an arrow key sequence
+			if i+2 < len(b) && (b[i+1] == '[' || b[i+1] == 'O') {
+				switch b[i+2] {
+				case 'A':
+					w.move(-1)
+				case 'B':
+					w.move(1)
+				}
+				i += 2
+				continue
+			}
+
+			if i == len(b)-1 {
+				return true
+			}
+		case 16: // Ctrl-P
+			w.move(-1)
+		case 14: // Ctrl-N
+			w.move(1)
+		case '\r', '\n':
+			w.copySelected()
+		case 127, 8: // Backspace
+			if len(w.query) > 0 {
+				w.query = w.query[:len(w.query)-1]
+				filterChanged = true
+			}
+		case 21: // Ctrl-U
+			w.query = nil
+			filterChanged = true
+		default:
+			if c < ' ' {
+				continue
+			}
+
+			r, size := utf8.DecodeRune(b[i:])
+			w.query = append(w.query, r)
+			filterChanged = true
+			i += size - 1
+		}
+	}
+
+	if filterChanged {
+		w.filter()
+	}
+
+	return false
+}
+
+func (w *Watcher) move(delta int) {
+	w.selected += delta
+	if w.selected >= len(w.outputs) {
+		w.selected = len(w.outputs) - 1
+	}
+
+	if w.selected < 0 {
+		w.selected = 0
+	}
+}
+
+func (w *Watcher) filter() {
+	query, err := ParseQuery(string(w.query))
+	if err != nil {
+		// likely still typing a quote or regexp, keep the last results
+		w.status = err.Error()
+		return
+	}
+
+	w.status = ""
+	w.selected = 0
+	w.offset = 0
+	if w.agent != nil {
+		// the agent filters on every redraw
+		return
+	}
+
+	w.tokens = w.Device.rankTokens(query, w.sort)
+}
+
+func (w *Watcher) copySelected() {
+	if w.selected >= len(w.outputs) {
+		return
+	}
+
+	o := w.outputs[w.selected]
+	if o.Error != nil {
+		w.status = o.Error.Error()
+		return
+	}
+
+	if err := w.Device.CopyCode(o, w.clipboard); err != nil {
+		w.status = err.Error()
+		return
+	}
+
+	w.status = fmt.Sprintf("Copied code of %s", o.Title())
+}
+
+// codes of the filtered tokens, from the agent if one is running
+func (w *Watcher) codes(now time.Time) []Output {
+	if w.agent != nil {
+		outputs, err := w.agent.FindCodes(CodeRequest{Query: string(w.query), Sort: w.sort})
+		switch {
+		case errors.Is(err, ErrInvalidQuery):
+			// likely still typing, keep the last results
+			return w.outputs
+		case err != nil:
+			w.status = err.Error()
+		}
+
+		return outputs
+	}
+
+	outputs := make([]Output, 0, len(w.tokens))
+	for _, tk := range w.tokens {
+		outputs = append(outputs, CalcTokenAt(tk, now, 0))
+	}
+
+	return outputs
+}
+
+// size of the terminal, 80x24 when unknown
+func (w *Watcher) size() (int, int) {
+	width, height, err := terminal.GetSize(w.fd)
+	if err != nil || width <= 0 || height <= 0 {
+		return 80, 24
+	}
+
+	return width, height
+}
+
+func (w *Watcher) listHeight() int {
+	h := w.height - chromeLines
+	if h < 1 {
+		return 1
+	}
+
+	return h
+}
+
+// tick redraw everything when a code rolled over or the terminal was resized,
+// otherwise only update the countdown bars once a second
+func (w *Watcher) tick(now time.Time) {
+	if now.Unix() == w.lastSecond {
+		return
+	}
+
+	width, height := w.size()
+	if width != w.width || height != w.height {
+		w.redraw()
+		return
+	}
+
+	for i := w.offset; i < len(w.outputs) && i < w.offset+w.listHeight(); i++ {
+		o := w.outputs[i]
+		if o.Error == nil && now.Unix()/int64(o.Period) != w.drawnAt.Unix()/int64(o.Period) {
+			w.redraw()
+			return
+		}
+	}
+
+	w.lastSecond = now.Unix()
+	w.updateBars(now)
+}
+
+func (w *Watcher) redraw() {
+	now := time.Now()
+	w.lastSecond = now.Unix()
+	w.drawnAt = now
+	w.width, w.height = w.size()
+
+	w.outputs = w.codes(now)
+	if w.selected >= len(w.outputs) {
+		w.selected = 0
+	}
+
+	if w.selected < w.offset {
+		w.offset = w.selected
+	}
+
+	if w.selected >= w.offset+w.listHeight() {
+		w.offset = w.selected - w.listHeight() + 1
+	}
+
+	w.out.WriteString(clearScreen)
+	w.out.WriteString(truncate("authy watch  type to filter · ↑/↓ select · Enter copy · Esc quit", w.width))
+	w.out.WriteString("\r\n" + truncate("> "+string(w.query), w.width) + "\r\n\r\n")
+
+	if len(w.outputs) == 0 {
+		w.out.WriteString("  No matching OTP tokens")
+	}
+
+	for i := w.offset; i < len(w.outputs) && i < w.offset+w.listHeight(); i++ {
+		w.writeRow(i, now)
+	}
+
+	fmt.Fprintf(w.out, "\033[%d;1H%s", w.height, truncate(w.status, w.width))
+	w.out.Flush()
+}
+
+func (w *Watcher) writeRow(i int, now time.Time) {
+	o := w.outputs[i]
+
+	marker := "  "
+	if i == w.selected {
+		marker = "> "
+		w.out.WriteString(reverse)
+	}
+
+	fmt.Fprintf(w.out, "\033[%d;1H%s%s ", listLine+i-w.offset, marker, bar(o, now))
+	if o.Error != nil {
+		fmt.Fprintf(w.out, "%-9s ", "-")
+	} else {
+		fmt.Fprintf(w.out, "%-9s ", o.Code)
+	}
+
+	used := barCol + barWidth + 7 + 10
+	w.out.WriteString(truncate(o.DecoratedTitle(), w.width-used))
+	if o.Error != nil {
+		w.out.WriteString(truncate("  "+o.Error.Error(), w.width-used-textWidth(o.DecoratedTitle())))
+	}
+
+	w.out.WriteString(reset + clearLine)
+}
+
+// updateBars redraw only the countdown bars of visible rows
+func (w *Watcher) updateBars(now time.Time) {
+	for i := w.offset; i < len(w.outputs) && i < w.offset+w.listHeight(); i++ {
+		if i == w.selected {
+			w.out.WriteString(reverse)
+		}
+
+		fmt.Fprintf(w.out, "\033[%d;%dH%s", listLine+i-w.offset, barCol, bar(w.outputs[i], now))
+		w.out.WriteString(reset)
+	}
+
+	w.out.Flush()
+}
+
+// bar countdown bar and seconds left, red when about to expire
+func bar(o Output, now time.Time) string {
+	if o.Error != nil || o.Period <= 0 {
+		return strings.Repeat(" ", barWidth+6)
+	}
+
+	remain := o.Period - int(now.Unix()%int64(o.Period))
+	filled := remain * barWidth / o.Period
+
+	b := strings.Repeat("█", filled) + strings.Repeat("░", barWidth-filled)
+	secs := fmt.Sprintf("%3ds", remain)
+	if remain <= nearExpirySecs {
+		return red + b + " " + secs + defaultFg + " "
+	}
+
+	return b + " " + secs + " "
+}
+
+// truncate s to width terminal columns
+func truncate(s string, width int) string {
+	if width <= 0 {
+		return ""
+	}
+
+	var (
+		b    strings.Builder
+		used int
+	)
+
+	for _, r := range s {
+		w := runeWidth(r)
+		if used+w > width {
+			break
+		}
+
+		used += w
+		b.WriteRune(r)
+	}
+
+	return b.String()
+}
+
+func textWidth(s string) int {
+	n := 0
+	for _, r := range s {
+		n += runeWidth(r)
+	}
+
+	return n
+}
+
+// runeWidth terminal columns of r, wide for CJK and fullwidth forms
+func runeWidth(r rune) int {
+	switch {
+	case unicode.Is(unicode.Mn, r):
+		return 0
+	case unicode.In(r, unicode.Han, unicode.Hangul, unicode.Hiragana, unicode.Katakana),
+		r >= 0xFF01 && r <= 0xFF60,
+		r >= 0x3000 && r <= 0x303F:
+		return 2
+	}
+
+	return 1
+}
@@ -0,0 +1,110 @@
+package totp
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Key otpauth://totp/ URI中的token信息
+type Key struct {
+	Secret  string
+	Issuer  string
+	Account string
+	// Digits code长度, 0为6位
+	Digits int
+	// Period 时间间隔(秒), 0为INTERVAL
+	Period int
+}
+
+// ParseURI 解析otpauth://totp/Issuer:account?secret=...&issuer=...&digits=6&period=30
+func ParseURI(s string) (k Key, err error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return
+	}
+
+	if u.Scheme != "otpauth" || u.Host != "totp" {
+		return k, fmt.Errorf("Not an otpauth://totp/ URI: %s", s)
+	}
+
+	label := strings.TrimPrefix(u.Path, "/")
+	if i := strings.Index(label, ":"); i >= 0 {
+		k.Issuer, k.Account = strings.TrimSpace(label[:i]), strings.TrimSpace(label[i+1:])
+	} else {
+		k.Account = label
+	}
+
+	q := u.Query()
+	if issuer := q.Get("issuer"); len(issuer) > 0 {
+		k.Issuer = issuer
+	}
+
+	k.Secret = strings.ToUpper(strings.TrimRight(q.Get("secret"), "="))
+	if len(k.Secret) == 0 {
+		return k, errors.New("otpauth URI has no secret")
+	}
+
+	if _, err = DefaultNewBase32Decode().Decode(k.Secret); err != nil {
+		return k, fmt.Errorf("Invalid secret: %v", err)
+	}
+
+	if alg := q.Get("algorithm"); len(alg) > 0 && !strings.EqualFold(alg, "SHA1") {
+		return k, fmt.Errorf("Unsupported algorithm %s, only SHA1", alg)
+	}
+
+	if v := q.Get("digits"); len(v) > 0 {
+		if k.Digits, err = strconv.Atoi(v); err != nil || !ValidDigits(k.Digits) {
+			return k, fmt.Errorf("Invalid digits %q", v)
+		}
+	}
+
+	if v := q.Get("period"); len(v) > 0 {
+		if k.Period, err = strconv.Atoi(v); err != nil || !ValidPeriod(k.Period) {
+			return k, fmt.Errorf("Invalid period %q", v)
+		}
+	}
+
+	return k, nil
+}
+
+// ValidDigits ParseURI接受的code长度, 1到9位
+func ValidDigits(digits int) bool {
+	return digits >= 1 && digits <= 9
+}
+
+// ValidPeriod ParseURI接受的时间间隔, 至少1秒
+func ValidPeriod(period int) bool {
+	return period >= 1
+}
+
+// URI 生成otpauth://totp/ URI, 默认的digits和period省略
+func (k Key) URI() string {
+	label := k.Account
+	if len(k.Issuer) > 0 {
+		label = k.Issuer + ":" + k.Account
+	}
+
+	q := url.Values{}
+	q.Set("secret", k.Secret)
+	if len(k.Issuer) > 0 {
+		q.Set("issuer", k.Issuer)
+	}
+	if k.Digits > 0 && k.Digits != defaultCodeLength {
+		q.Set("digits", strconv.Itoa(k.Digits))
+	}
+	if k.Period > 0 && k.Period != INTERVAL {
+		q.Set("period", strconv.Itoa(k.Period))
+	}
+
+	u := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + label,
+		RawQuery: q.Encode(),
+	}
+
+	return u.String()
+}
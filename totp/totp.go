@@ -2,10 +2,11 @@ package totp
 
 import (
 	"crypto/hmac"
+	"crypto/rand"
 	"crypto/sha1"
+	"encoding/base32"
 	"errors"
 	"fmt"
-	"math/rand"
 	"strconv"
 	"strings"
 	"time"
@@ -140,7 +141,7 @@ func GenerateResponseCode(secret string, challenge int64, codeLength int) (strin
 
 	offset := int(hash[len(hash)-1] & 0x0F)
 	truncatedHash := hashToInt(hash, offset) & 0x7FFFFFFF
-	pinValue := int(truncatedHash % PIN_MODULO)
+	pinValue := int(truncatedHash % pinModulo(codeLength))
 	code := strconv.Itoa(pinValue)
 
 	if len(code) >= codeLength {
@@ -150,25 +151,77 @@ func GenerateResponseCode(secret string, challenge int64, codeLength int) (strin
 	return strings.Repeat("0", codeLength-len(code)) + code, nil
 }
 
-// NewTotpToken 新生成token
+// pinModulo 10的codeLength次方, 不合法的长度使用PIN_MODULO
+func pinModulo(codeLength int) uint32 {
+	if codeLength <= 0 || codeLength > 9 {
+		return PIN_MODULO
+	}
+
+	m := uint32(1)
+	for i := 0; i < codeLength; i++ {
+		m *= 10
+	}
+	return m
+}
+
+// GetChallengeAt 获取t时刻的时间token, period为时间间隔(秒)
+func GetChallengeAt(t time.Time, period int) int64 {
+	if period <= 0 {
+		period = INTERVAL
+	}
+	return t.Unix() / int64(period)
+}
+
+// CodeAt 获取t时刻的code及其剩余有效秒数
+func CodeAt(secret string, codeLength, period int, t time.Time) (string, int, error) {
+	if period <= 0 {
+		period = INTERVAL
+	}
+
+	challenge := GetChallengeAt(t, period)
+	code, err := GenerateResponseCode(secret, challenge, codeLength)
+	if err != nil {
+		return "", 0, err
+	}
+
+	return code, int((challenge+1)*int64(period) - t.Unix()), nil
+}
+
+// NewTotpToken 新生成length个base32字符的token, 使用crypto/rand
 func NewTotpToken(length int) string {
-	defaultBase32Decode := NewBase32Decode(DEFAULT_BASE32_STRING)
-	rand.Seed(time.Now().UnixNano())
-	var (
-		num int
-	)
 	if length == 0 {
 		length = 12
 	}
 
 	data := make([]byte, length)
-	for i := 0; i < length; i++ {
-		num = rand.Intn(length - 1)
-		data[i] = byte(defaultBase32Decode.encode[num])
+	if _, err := rand.Read(data); err != nil {
+		panic(err)
+	}
+
+	// 256是32的倍数, 取低5位分布均匀
+	for i := range data {
+		data[i] = DEFAULT_BASE32_STRING[data[i]&31]
 	}
 	return string(data)
 }
 
+// DefaultSecretSize 默认secret字节数, 与HMAC-SHA1输出等长
+const DefaultSecretSize = 20
+
+// GenerateSecret 生成size字节的随机secret, 返回无padding的base32编码
+func GenerateSecret(size int) (string, error) {
+	if size <= 0 {
+		size = DefaultSecretSize
+	}
+
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b), nil
+}
+
 // GetTotpCode 获取totpcode, 这里会生成3个code，当前时间，前30s,后30s 为了预防客户端跟服务端的时间差太大
 func GetTotpCode(secret string, codeLength int) []string {
 	tries := []int64{-1, 0, 1}
@@ -183,16 +236,8 @@ func GetTotpCode(secret string, codeLength int) []string {
 
 const defaultCodeLength = 6
 
-// ValidTotpCode 验证totp code
+// ValidTotpCode 验证totp code, 允许前后30s的时钟偏差
 func ValidTotpCode(totpToken, totpCode string) bool {
-	flag := false
-	code := GetTotpCode(totpToken, defaultCodeLength)
-	for _, c := range code {
-		if totpCode == c {
-			flag = true
-			break
-		}
-	}
-
-	return flag
+	_, ok, _ := Verifier{Skew: DefaultSkew}.Verify(totpToken, totpCode, time.Now())
+	return ok
 }
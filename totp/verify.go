@@ -0,0 +1,52 @@
+package totp
+
+import (
+	"crypto/subtle"
+	"time"
+)
+
+// DefaultSkew 默认允许前后各1个时间间隔的时钟偏差
+const DefaultSkew = 1
+
+// Verifier totp code校验器
+type Verifier struct {
+	// Digits code长度, 0为6位
+	Digits int
+	// Period 时间间隔(秒), 0为INTERVAL
+	Period int
+	// Skew 允许的时钟偏差, 前后各Skew个时间间隔
+	Skew int
+}
+
+// Verify 校验t时刻的code, 返回匹配的时间间隔偏移, 负数表示code来自过去的时间间隔(生成方时钟偏慢)
+func (v Verifier) Verify(secret, code string, t time.Time) (offset int, ok bool, err error) {
+	digits := v.Digits
+	if digits <= 0 {
+		digits = defaultCodeLength
+	}
+
+	if len(code) != digits {
+		return 0, false, nil
+	}
+
+	challenge := GetChallengeAt(t, v.Period)
+
+	// 从偏移0开始向两侧查找, 离当前时间最近的匹配优先
+	for i := 0; i <= 2*v.Skew; i++ {
+		offset = (i + 1) / 2
+		if i%2 == 1 {
+			offset = -offset
+		}
+
+		expected, err := GenerateResponseCode(secret, challenge+int64(offset), digits)
+		if err != nil {
+			return 0, false, err
+		}
+
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return offset, true, nil
+		}
+	}
+
+	return 0, false, nil
+}
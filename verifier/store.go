@@ -0,0 +1,151 @@
+package verifier
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// State verification state of one identity
+type State struct {
+	// Secret fingerprint of the secret LastStep and Drift belong to
+	Secret string `json:"secret,omitempty"`
+	// LastStep time step of the last accepted code, earlier steps are replays
+	LastStep int64 `json:"last_step,omitempty"`
+	// Drift learned clock drift of the authenticator in periods
+	Drift int `json:"drift,omitempty"`
+
+	// Failures failed attempts in a row
+	Failures int `json:"failures,omitempty"`
+	// Lockouts lockouts since the last accepted code
+	Lockouts    int       `json:"lockouts,omitempty"`
+	LockedUntil time.Time `json:"locked_until,omitempty"`
+}
+
+// Store keeps State per identity
+type Store interface {
+	// Update call fn with the state of identity, the zero State if there is none,
+	// and save it unless fn fails. Updates of one identity must not interleave
+	Update(identity string, fn func(*State) error) error
+}
+
+// MemoryStore state lost on exit
+type MemoryStore struct {
+	mu     sync.Mutex
+	states map[string]State
+}
+
+// NewMemoryStore ..
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{states: map[string]State{}}
+}
+
+// Update ..
+func (m *MemoryStore) Update(identity string, fn func(*State) error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s := m.states[identity]
+	if err := fn(&s); err != nil {
+		return err
+	}
+
+	m.states[identity] = s
+	return nil
+}
+
+// FileStore state of all identities in one JSON file. Updates hold a lock on the file
+// path+".lock", so several processes, e.g. 'authy radius' and 'authy enroll', can share it
+type FileStore struct {
+	mu   sync.Mutex
+	path string
+}
+
+// NewFileStore ..
+func NewFileStore(path string) *FileStore {
+	return &FileStore{path: path}
+}
+
+// Update ..
+func (f *FileStore) Update(identity string, fn func(*State) error) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	unlock, err := f.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	states, err := f.load()
+	if err != nil {
+		return err
+	}
+
+	s := states[identity]
+	if err = fn(&s); err != nil {
+		return err
+	}
+
+	states[identity] = s
+	return f.save(states)
+}
+
+// lock take the lock shared with other processes, blocking until it is free
+func (f *FileStore) lock() (func(), error) {
+	lf, err := os.OpenFile(f.path+".lock", os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = lockFile(lf); err != nil {
+		lf.Close()
+		return nil, err
+	}
+
+	return func() {
+		unlockFile(lf)
+		lf.Close()
+	}, nil
+}
+
+func (f *FileStore) load() (map[string]State, error) {
+	states := map[string]State{}
+
+	b, err := os.ReadFile(f.path)
+	if os.IsNotExist(err) {
+		return states, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return states, json.Unmarshal(b, &states)
+}
+
+// save write to a temporary file first, so a crash never leaves a truncated file
+func (f *FileStore) save(states map[string]State) error {
+	b, err := json.MarshalIndent(states, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), filepath.Base(f.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), f.path)
+}
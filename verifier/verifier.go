@@ -0,0 +1,256 @@
+// Package verifier checks TOTP codes as a second factor: every code is accepted only
+// once, failed attempts are rate limited per identity and the clock drift of each
+// identity's authenticator is learned. State is kept in a pluggable Store.
+package verifier
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/momaek/authy/totp"
+)
+
+// Clock source of the current time, replaced in tests
+type Clock interface {
+	Now() time.Time
+}
+
+// ClockFunc function as Clock
+type ClockFunc func() time.Time
+
+// Now ..
+func (f ClockFunc) Now() time.Time {
+	return f()
+}
+
+// SystemClock wall clock
+var SystemClock Clock = ClockFunc(time.Now)
+
+var (
+	// ErrInvalidCode code does not match within the skew window
+	ErrInvalidCode = errors.New("Invalid code")
+	// ErrReplayedCode code of a time step that was already used
+	ErrReplayedCode = errors.New("Code already used")
+	// ErrLocked too many failed attempts
+	ErrLocked = errors.New("Too many failed attempts")
+)
+
+// LockedError identity is locked out after too many failed attempts
+type LockedError struct {
+	Until time.Time
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("%v, locked until %s", ErrLocked, e.Until.Format(time.RFC3339))
+}
+
+// Is matches ErrLocked
+func (e *LockedError) Is(target error) bool {
+	return target == ErrLocked
+}
+
+// Config of a Verifier, zero values use the defaults
+type Config struct {
+	// Digits code length, default 6
+	Digits int
+	// Period seconds each code is valid, default 30
+	Period int
+	// Skew accepted periods before and after the expected one, default 1
+	Skew int
+	// MaxDrift learned drift is capped to this many periods each way, default 10
+	MaxDrift int
+
+	// MaxFailures failed attempts in a row before locking out, default 5
+	MaxFailures int
+	// Lockout how long a locked out identity is rejected, doubled on every
+	// further lockout until a code is accepted, default 5 minutes
+	Lockout time.Duration
+
+	// Store default an in-memory store
+	Store Store
+	// Clock default SystemClock
+	Clock Clock
+}
+
+// Verifier replay protected TOTP verification, safe for concurrent use
+// as long as its Store is
+type Verifier struct {
+	conf Config
+}
+
+// New ..
+func New(conf Config) *Verifier {
+	if conf.Period <= 0 {
+		conf.Period = totp.INTERVAL
+	}
+
+	if conf.Skew <= 0 {
+		conf.Skew = totp.DefaultSkew
+	}
+
+	if conf.MaxDrift <= 0 {
+		conf.MaxDrift = 10
+	}
+
+	if conf.MaxFailures <= 0 {
+		conf.MaxFailures = 5
+	}
+
+	if conf.Lockout <= 0 {
+		conf.Lockout = 5 * time.Minute
+	}
+
+	if conf.Store == nil {
+		conf.Store = NewMemoryStore()
+	}
+
+	if conf.Clock == nil {
+		conf.Clock = SystemClock
+	}
+
+	return &Verifier{conf: conf}
+}
+
+// Result of an accepted code
+type Result struct {
+	// Step time step of the accepted code
+	Step int64
+	// Offset periods between the accepted code and now, negative when the
+	// authenticator's clock is behind
+	Offset int
+	// Drift learned drift of the identity after this code
+	Drift int
+}
+
+// Verify check code of identity against secret. Errors are ErrInvalidCode,
+// ErrReplayedCode, a *LockedError, or failures of the store
+func (v *Verifier) Verify(identity, secret, code string) (res Result, err error) {
+	now := v.conf.Clock.Now()
+
+	storeErr := v.conf.Store.Update(identity, func(s *State) error {
+		if now.Before(s.LockedUntil) {
+			err = &LockedError{Until: s.LockedUntil}
+			return nil
+		}
+
+		// steps and drift belong to one secret, start over when it changed
+		if fp := fingerprint(secret); s.Secret != fp {
+			s.Secret = fp
+			s.LastStep = 0
+			s.Drift = 0
+		}
+
+		res, err = v.check(s, secret, code, now)
+		switch {
+		case errors.Is(err, ErrInvalidCode), errors.Is(err, ErrReplayedCode):
+			v.fail(s, now)
+			return nil
+		case err != nil:
+			return err
+		}
+
+		s.LastStep = res.Step
+		s.Drift = res.Drift
+		s.Failures = 0
+		s.Lockouts = 0
+		return nil
+	})
+	if storeErr != nil {
+		return Result{}, storeErr
+	}
+
+	return
+}
+
+// Fail count a failed attempt of identity rejected before its code was checked,
+// e.g. for a wrong password, so those attempts are rate limited too. Returns a
+// *LockedError while identity is locked out
+func (v *Verifier) Fail(identity string) (err error) {
+	now := v.conf.Clock.Now()
+
+	storeErr := v.conf.Store.Update(identity, func(s *State) error {
+		if now.Before(s.LockedUntil) {
+			err = &LockedError{Until: s.LockedUntil}
+			return nil
+		}
+
+		v.fail(s, now)
+		return nil
+	})
+	if storeErr != nil {
+		return storeErr
+	}
+
+	return
+}
+
+// check code around the step expected with the learned drift
+func (v *Verifier) check(s *State, secret, code string, now time.Time) (Result, error) {
+	period := time.Duration(v.conf.Period) * time.Second
+	expected := now.Add(time.Duration(s.Drift) * period)
+
+	tv := totp.Verifier{
+		Digits: v.conf.Digits,
+		Period: v.conf.Period,
+		Skew:   v.conf.Skew,
+	}
+
+	offset, ok, err := tv.Verify(secret, code, expected)
+	if err != nil {
+		return Result{}, err
+	}
+
+	if !ok {
+		return Result{}, ErrInvalidCode
+	}
+
+	res := Result{
+		Step:   totp.GetChallengeAt(expected, v.conf.Period) + int64(offset),
+		Offset: s.Drift + offset,
+		Drift:  clamp(s.Drift+offset, v.conf.MaxDrift),
+	}
+
+	if s.LastStep > 0 && res.Step <= s.LastStep {
+		return Result{}, ErrReplayedCode
+	}
+
+	return res, nil
+}
+
+// fail count a failed attempt, locking out after MaxFailures in a row
+func (v *Verifier) fail(s *State, now time.Time) {
+	s.Failures++
+	if s.Failures < v.conf.MaxFailures {
+		return
+	}
+
+	lockout := v.conf.Lockout
+	for i := 0; i < s.Lockouts && lockout < 24*time.Hour; i++ {
+		lockout *= 2
+	}
+
+	s.Failures = 0
+	s.Lockouts++
+	s.LockedUntil = now.Add(lockout)
+}
+
+// fingerprint identifies a secret without storing it
+func fingerprint(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:8])
+}
+
+func clamp(n, limit int) int {
+	if n > limit {
+		return limit
+	}
+
+	if n < -limit {
+		return -limit
+	}
+
+	return n
+}
@@ -0,0 +1,321 @@
+package verifier
+
+import (
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/momaek/authy/totp"
+)
+
+const (
+	testSecret      = "JBSWY3DPEHPK3PXP"
+	otherTestSecret = "JBSWY3DPEHPK3PXQ"
+)
+
+// testClock settable clock starting at a period boundary
+type testClock struct {
+	now time.Time
+}
+
+func newTestClock() *testClock {
+	return &testClock{now: time.Unix(1700000010, 0)}
+}
+
+func (c *testClock) clock() Clock {
+	return ClockFunc(func() time.Time { return c.now })
+}
+
+func (c *testClock) advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+// codeAt code of secret offset periods from now
+func codeAt(t *testing.T, secret string, now time.Time, offset int) string {
+	t.Helper()
+
+	code, _, err := totp.CodeAt(secret, 6, totp.INTERVAL, now.Add(time.Duration(offset*totp.INTERVAL)*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return code
+}
+
+// wrongCode a code that doesn't match now
+func wrongCode(t *testing.T, now time.Time) string {
+	t.Helper()
+
+	valid := map[string]bool{}
+	for offset := -1; offset <= 1; offset++ {
+		valid[codeAt(t, testSecret, now, offset)] = true
+	}
+
+	for _, code := range []string{"000000", "111111", "222222", "333333"} {
+		if !valid[code] {
+			return code
+		}
+	}
+
+	t.Fatal("no wrong code found")
+	return ""
+}
+
+func TestVerifyRejectsReplay(t *testing.T) {
+	c := newTestClock()
+	v := New(Config{Clock: c.clock()})
+
+	code := codeAt(t, testSecret, c.now, 0)
+	if _, err := v.Verify("alice", testSecret, code); err != nil {
+		t.Fatalf("first use: %v", err)
+	}
+
+	if _, err := v.Verify("alice", testSecret, code); !errors.Is(err, ErrReplayedCode) {
+		t.Fatalf("second use: got %v, want ErrReplayedCode", err)
+	}
+
+	// an older code within the skew window is a replay too
+	if _, err := v.Verify("alice", testSecret, codeAt(t, testSecret, c.now, -1)); !errors.Is(err, ErrReplayedCode) {
+		t.Fatalf("older code: got %v, want ErrReplayedCode", err)
+	}
+
+	// other identities keep their own steps
+	if _, err := v.Verify("bob", testSecret, code); err != nil {
+		t.Fatalf("other identity: %v", err)
+	}
+
+	c.advance(totp.INTERVAL * time.Second)
+	if _, err := v.Verify("alice", testSecret, codeAt(t, testSecret, c.now, 0)); err != nil {
+		t.Fatalf("next period: %v", err)
+	}
+}
+
+func TestVerifyLockoutDoubles(t *testing.T) {
+	c := newTestClock()
+	v := New(Config{Clock: c.clock(), MaxFailures: 3, Lockout: time.Minute})
+
+	lockout := func(want time.Duration) {
+		t.Helper()
+
+		for i := 0; i < 3; i++ {
+			if _, err := v.Verify("alice", testSecret, wrongCode(t, c.now)); !errors.Is(err, ErrInvalidCode) {
+				t.Fatalf("attempt %d: got %v, want ErrInvalidCode", i+1, err)
+			}
+		}
+
+		_, err := v.Verify("alice", testSecret, codeAt(t, testSecret, c.now, 0))
+		var locked *LockedError
+		if !errors.As(err, &locked) || !errors.Is(err, ErrLocked) {
+			t.Fatalf("got %v, want a LockedError", err)
+		}
+
+		if got := locked.Until.Sub(c.now); got != want {
+			t.Fatalf("locked for %s, want %s", got, want)
+		}
+
+		c.advance(want)
+	}
+
+	lockout(time.Minute)
+	lockout(2 * time.Minute)
+	lockout(4 * time.Minute)
+
+	// an accepted code resets the backoff
+	if _, err := v.Verify("alice", testSecret, codeAt(t, testSecret, c.now, 0)); err != nil {
+		t.Fatalf("after lockout: %v", err)
+	}
+
+	c.advance(totp.INTERVAL * time.Second)
+	lockout(time.Minute)
+}
+
+func TestVerifyLearnsDrift(t *testing.T) {
+	c := newTestClock()
+	v := New(Config{Clock: c.clock()})
+
+	// the authenticator is two periods behind, outside the skew window at first
+	if _, err := v.Verify("alice", testSecret, codeAt(t, testSecret, c.now, -2)); !errors.Is(err, ErrInvalidCode) {
+		t.Fatalf("got %v, want ErrInvalidCode", err)
+	}
+
+	res, err := v.Verify("alice", testSecret, codeAt(t, testSecret, c.now, -1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.Offset != -1 || res.Drift != -1 {
+		t.Fatalf("got offset %d drift %d, want -1 -1", res.Offset, res.Drift)
+	}
+
+	// the window now centers on the learned drift
+	c.advance(2 * totp.INTERVAL * time.Second)
+	res, err = v.Verify("alice", testSecret, codeAt(t, testSecret, c.now, -2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.Offset != -2 || res.Drift != -2 {
+		t.Fatalf("got offset %d drift %d, want -2 -2", res.Offset, res.Drift)
+	}
+}
+
+func TestVerifyDriftIsCapped(t *testing.T) {
+	c := newTestClock()
+	v := New(Config{Clock: c.clock(), MaxDrift: 1})
+
+	for i := 0; i < 3; i++ {
+		res, err := v.Verify("alice", testSecret, codeAt(t, testSecret, c.now, 1))
+		if err != nil {
+			t.Fatalf("attempt %d: %v", i+1, err)
+		}
+
+		if res.Drift != 1 {
+			t.Fatalf("attempt %d: drift %d, want 1", i+1, res.Drift)
+		}
+
+		c.advance(totp.INTERVAL * time.Second)
+	}
+}
+
+func TestVerifyResetsOnSecretChange(t *testing.T) {
+	c := newTestClock()
+	store := NewMemoryStore()
+	v := New(Config{Clock: c.clock(), Store: store})
+
+	if _, err := v.Verify("alice", testSecret, codeAt(t, testSecret, c.now, 1)); err != nil {
+		t.Fatal(err)
+	}
+
+	// the step and drift of the old secret must not reject codes of the new one
+	res, err := v.Verify("alice", otherTestSecret, codeAt(t, otherTestSecret, c.now, 0))
+	if err != nil {
+		t.Fatalf("new secret: %v", err)
+	}
+
+	if res.Drift != 0 {
+		t.Fatalf("drift %d carried over to the new secret", res.Drift)
+	}
+
+	store.Update("alice", func(s *State) error {
+		if s.Secret != fingerprint(otherTestSecret) {
+			t.Errorf("state belongs to %q, want the new secret", s.Secret)
+		}
+		return nil
+	})
+}
+
+func TestFileStoreRoundTrip(t *testing.T) {
+	c := newTestClock()
+	path := filepath.Join(t.TempDir(), "state.json")
+
+	v := New(Config{Clock: c.clock(), Store: NewFileStore(path)})
+	code := codeAt(t, testSecret, c.now, 1)
+	if _, err := v.Verify("alice", testSecret, code); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := v.Verify("alice", testSecret, wrongCode(t, c.now)); !errors.Is(err, ErrInvalidCode) {
+		t.Fatalf("got %v, want ErrInvalidCode", err)
+	}
+
+	var got State
+	if err := NewFileStore(path).Update("alice", func(s *State) error {
+		got = *s
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := State{
+		Secret:   fingerprint(testSecret),
+		LastStep: totp.GetChallengeAt(c.now, totp.INTERVAL) + 1,
+		Drift:    1,
+		Failures: 1,
+	}
+	if got != want {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+
+	// a fresh verifier on the same file still rejects the replay
+	v = New(Config{Clock: c.clock(), Store: NewFileStore(path)})
+	if _, err := v.Verify("alice", testSecret, code); !errors.Is(err, ErrReplayedCode) {
+		t.Fatalf("got %v, want ErrReplayedCode", err)
+	}
+}
+
+func TestFileStoreKeepsStateOnError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	f := NewFileStore(path)
+
+	if err := f.Update("alice", func(s *State) error {
+		s.Failures = 2
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	boom := errors.New("boom")
+	if err := f.Update("alice", func(s *State) error {
+		s.Failures = 7
+		return boom
+	}); err != boom {
+		t.Fatalf("got %v, want the callback error", err)
+	}
+
+	f.Update("alice", func(s *State) error {
+		if s.Failures != 2 {
+			t.Errorf("failures %d, want 2", s.Failures)
+		}
+		return nil
+	})
+}
+
+func TestFileStoresShareTheFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+
+	// separate stores, like separate processes, only share the lock on the file
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(f *FileStore) {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				if err := f.Update("alice", func(s *State) error {
+					s.Failures++
+					return nil
+				}); err != nil {
+					t.Error(err)
+				}
+			}
+		}(NewFileStore(path))
+	}
+	wg.Wait()
+
+	NewFileStore(path).Update("alice", func(s *State) error {
+		if s.Failures != 40 {
+			t.Errorf("failures %d, want 40, updates were lost", s.Failures)
+		}
+		return nil
+	})
+}
+
+func TestFailCountsTowardsLockout(t *testing.T) {
+	c := newTestClock()
+	v := New(Config{Clock: c.clock(), MaxFailures: 2, Lockout: time.Minute})
+
+	for i := 0; i < 2; i++ {
+		if err := v.Fail("alice"); err != nil {
+			t.Fatalf("attempt %d: %v", i+1, err)
+		}
+	}
+
+	if err := v.Fail("alice"); !errors.Is(err, ErrLocked) {
+		t.Fatalf("got %v, want ErrLocked", err)
+	}
+
+	if _, err := v.Verify("alice", testSecret, codeAt(t, testSecret, c.now, 0)); !errors.Is(err, ErrLocked) {
+		t.Fatalf("got %v, want ErrLocked", err)
+	}
+}